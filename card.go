@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// Card is the data rendered by the deployment card template.
+	Card struct {
+		Playbooks   []string     `json:"playbooks"`
+		Inventories []string     `json:"inventories"`
+		Limit       string       `json:"limit,omitempty"`
+		Tags        string       `json:"tags,omitempty"`
+		SkipTags    string       `json:"skip_tags,omitempty"`
+		Check       bool         `json:"check"`
+		Diff        bool         `json:"diff"`
+		Status      string       `json:"status"`
+		Duration    string       `json:"duration"`
+		Hosts       []CardHost   `json:"hosts"`
+		FailedTasks []CardFailed `json:"failed_tasks"`
+	}
+
+	// CardHost is the recap of a single host.
+	CardHost struct {
+		Inventory string `json:"inventory"`
+		HostStats
+		Status string `json:"status"`
+	}
+
+	// CardFailed is a task which failed on a host.
+	CardFailed struct {
+		Inventory string `json:"inventory"`
+		Play      string `json:"play"`
+		Task      string `json:"task"`
+		Host      string `json:"host"`
+		Status    string `json:"status"`
+		Message   string `json:"message,omitempty"`
+	}
+
+	cardInput struct {
+		Schema string          `json:"schema"`
+		Data   json.RawMessage `json:"data"`
+	}
+)
+
+// cardSchema is the adaptive card template used to render the card.
+const cardSchema = "https://drone-plugins.github.io/drone-ansible/card.json"
+
+// writeCard renders the results of the playbook run as a Drone card.
+func (p *Plugin) writeCard(duration time.Duration, runErr error) error {
+	card := Card{
+		Playbooks:   p.Config.Playbooks,
+		Inventories: p.Config.Inventories,
+		Limit:       p.Config.Limit,
+		Tags:        p.Config.Tags,
+		SkipTags:    p.Config.SkipTags,
+		Check:       p.Config.Check,
+		Diff:        p.Config.Diff,
+		Status:      status(runErr == nil),
+		Duration:    duration.Round(time.Second).String(),
+		Hosts:       []CardHost{},
+		FailedTasks: []CardFailed{},
+	}
+
+	for _, result := range p.Results {
+		for _, h := range result.Hosts {
+			card.Hosts = append(card.Hosts, CardHost{
+				Inventory: result.Inventory,
+				HostStats: *h,
+				Status:    status(h.Success()),
+			})
+		}
+
+		for _, play := range result.Plays {
+			for _, task := range play.Tasks {
+				if !task.Failed() {
+					continue
+				}
+
+				card.FailedTasks = append(card.FailedTasks, CardFailed{
+					Inventory: result.Inventory,
+					Play:      play.Name,
+					Task:      task.Name,
+					Host:      task.Host,
+					Status:    task.Status,
+					Message:   task.Message,
+				})
+			}
+		}
+	}
+
+	data, err := json.Marshal(card)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode card data")
+	}
+
+	content, err := json.Marshal(cardInput{
+		Schema: cardSchema,
+		Data:   data,
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "failed to encode card")
+	}
+
+	switch p.Config.CardPath {
+	case "/dev/stdout":
+		return writeCardTo(p.stdout, content)
+	case "/dev/stderr":
+		return writeCardTo(p.stderr, content)
+	}
+
+	if err := os.WriteFile(p.Config.CardPath, content, 0644); err != nil {
+		return errors.Wrap(err, "failed to write card")
+	}
+
+	return nil
+}
+
+// writeCardTo writes the card as escape sequence picked up by the runner.
+func writeCardTo(out io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	_, err := io.WriteString(out, "\u001B]1338;"+encoded+"\u001B]0m\n")
+	return err
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// ansibleCfg is an ansible.cfg which keeps the order of its sections
+	// and keys, so merged files stay readable.
+	ansibleCfg struct {
+		sections []*cfgSection
+	}
+
+	cfgSection struct {
+		name   string
+		keys   []string
+		values map[string]string
+	}
+)
+
+// parseAnsibleConfig decodes the config setting, a YAML or JSON object of
+// sections holding their keys.
+func parseAnsibleConfig(raw string) (map[string]map[string]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	sections := map[string]map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(raw), &sections); err != nil {
+		return nil, errors.Wrap(err, "failed to parse ansible config")
+	}
+
+	config := map[string]map[string]string{}
+
+	for name, keys := range sections {
+		config[name] = map[string]string{}
+
+		for key, value := range keys {
+			rendered, err := cfgValue(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid ansible config %s.%s", name, key)
+			}
+
+			config[name][key] = rendered
+		}
+	}
+
+	return config, nil
+}
+
+// cfgValue renders a YAML value in ansible.cfg syntax. Lists become comma
+// separated, null becomes empty.
+func cfgValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+
+		for _, item := range v {
+			switch item.(type) {
+			case []interface{}, map[string]interface{}:
+				return "", errors.New("nested lists and mappings are not supported")
+			}
+
+			rendered, err := cfgValue(item)
+			if err != nil {
+				return "", err
+			}
+
+			items = append(items, rendered)
+		}
+
+		return strings.Join(items, ","), nil
+	case map[string]interface{}:
+		return "", errors.New("nested mappings are not supported")
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// parseAnsibleCfg reads an ansible.cfg. Indented lines continue the value
+// of the previous key, comments are dropped.
+func parseAnsibleCfg(content string) *ansibleCfg {
+	var (
+		cfg     = &ansibleCfg{}
+		section *cfgSection
+		key     string
+	)
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";"):
+			continue
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			section = cfg.section(strings.TrimSpace(trimmed[1 : len(trimmed)-1]))
+			key = ""
+		case section == nil:
+			continue
+		case key != "" && (line[0] == ' ' || line[0] == '\t'):
+			section.values[key] += "\n" + trimmed
+		default:
+			parts := strings.SplitN(trimmed, "=", 2)
+			if len(parts) != 2 {
+				parts = strings.SplitN(trimmed, ":", 2)
+			}
+
+			key = strings.TrimSpace(parts[0])
+			value := ""
+			if len(parts) == 2 {
+				value = strings.TrimSpace(parts[1])
+			}
+
+			section.set(key, value)
+		}
+	}
+
+	return cfg
+}
+
+func (c *ansibleCfg) section(name string) *cfgSection {
+	for _, s := range c.sections {
+		if s.name == name {
+			return s
+		}
+	}
+
+	s := &cfgSection{
+		name:   name,
+		values: map[string]string{},
+	}
+
+	c.sections = append(c.sections, s)
+	return s
+}
+
+func (s *cfgSection) set(key, value string) {
+	if _, ok := s.values[key]; !ok {
+		s.keys = append(s.keys, key)
+	}
+	s.values[key] = value
+}
+
+func (c *ansibleCfg) String() string {
+	var b strings.Builder
+
+	for i, s := range c.sections {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+
+		fmt.Fprintf(&b, "[%s]\n", s.name)
+
+		for _, key := range s.keys {
+			value := strings.ReplaceAll(s.values[key], "\n", "\n    ")
+			fmt.Fprintf(&b, "%s = %s\n", key, value)
+		}
+	}
+
+	return b.String()
+}
+
+// ansibleConfig generates an ansible.cfg from the repository ansible.cfg
+// and the config setting, the commands pass it to ansible via
+// ANSIBLE_CONFIG.
+func (p *Plugin) ansibleConfig() error {
+	if len(p.Config.AnsibleConfig) == 0 && !p.Config.DisableHostKeyChecking {
+		return nil
+	}
+
+	// ANSIBLE_CONFIG replaces the lookup of ./ansible.cfg, so the file
+	// which would have been used is merged into the generated one
+	base := os.Getenv("ANSIBLE_CONFIG")
+	if base == "" {
+		base = "ansible.cfg"
+	}
+
+	cfg := &ansibleCfg{}
+
+	content, err := os.ReadFile(base)
+	switch {
+	case err == nil:
+		cfg = parseAnsibleCfg(string(content))
+	case !os.IsNotExist(err) || base != "ansible.cfg":
+		return errors.Wrapf(err, "failed to read ansible config %s", base)
+	}
+
+	if p.Config.DisableHostKeyChecking {
+		cfg.section("defaults").set("host_key_checking", "False")
+	}
+
+	names := make([]string, 0, len(p.Config.AnsibleConfig))
+	for name := range p.Config.AnsibleConfig {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		section := cfg.section(name)
+
+		for _, key := range sortedKeys(p.Config.AnsibleConfig[name]) {
+			section.set(key, p.Config.AnsibleConfig[name][key])
+		}
+	}
+
+	// ansible resolves relative paths in the config from its directory,
+	// so the generated file has to live next to the base config
+	dir, err := filepath.Abs(filepath.Dir(base))
+	if err != nil {
+		return errors.Wrap(err, "failed to resolve ansible config directory")
+	}
+
+	p.Config.GeneratedConfig = cfg.String()
+	p.Config.GeneratedConfigDir = dir
+
+	return nil
+}
+
+// env returns the environment for ansible commands, pointing them to the
+// virtualenv and the galaxy install directories.
+func (p *Plugin) env() []string {
+	env := os.Environ()
+
+	if p.virtualenv != "" {
+		env = append(env,
+			"VIRTUAL_ENV="+p.virtualenv,
+			"PATH="+prependPath(virtualenvBin(p.virtualenv), os.Getenv("PATH")),
+		)
+	}
+
+	if p.Config.GalaxyRolesPath != "" {
+		env = append(env, "ANSIBLE_ROLES_PATH="+prependPath(p.Config.GalaxyRolesPath, os.Getenv("ANSIBLE_ROLES_PATH")))
+	}
+
+	if p.Config.GalaxyCollectionsPath != "" {
+		env = append(env, "ANSIBLE_COLLECTIONS_PATH="+prependPath(p.Config.GalaxyCollectionsPath, os.Getenv("ANSIBLE_COLLECTIONS_PATH")))
+	}
+
+	return env
+}
+
+func prependPath(path, list string) string {
+	if list == "" {
+		return path
+	}
+	return path + string(os.PathListSeparator) + list
+}
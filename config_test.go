@@ -0,0 +1,198 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestParseAnsibleConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want map[string]map[string]string
+		err  string
+	}{
+		{name: "empty", raw: " "},
+		{
+			name: "scalars",
+			raw:  `{"defaults": {"forks": 10, "host_key_checking": false, "stdout_callback": "yaml"}}`,
+			want: map[string]map[string]string{
+				"defaults": {"forks": "10", "host_key_checking": "false", "stdout_callback": "yaml"},
+			},
+		},
+		{
+			name: "lists",
+			raw:  "defaults:\n  roles_path: [roles, vendor/roles]\n  callbacks_enabled:\n    - timer\n    - profile_tasks\n",
+			want: map[string]map[string]string{
+				"defaults": {"roles_path": "roles,vendor/roles", "callbacks_enabled": "timer,profile_tasks"},
+			},
+		},
+		{
+			name: "null",
+			raw:  "defaults:\n  callbacks_enabled:\n  vault_identity: ~\n",
+			want: map[string]map[string]string{
+				"defaults": {"callbacks_enabled": "", "vault_identity": ""},
+			},
+		},
+		{
+			name: "nested mapping",
+			raw:  "defaults:\n  callbacks:\n    timer: true\n",
+			err:  "invalid ansible config defaults.callbacks: nested mappings are not supported",
+		},
+		{
+			name: "nested list",
+			raw:  "defaults:\n  roles_path: [[roles]]\n",
+			err:  "invalid ansible config defaults.roles_path: nested lists and mappings are not supported",
+		},
+		{
+			name: "malformed",
+			raw:  `{"defaults": {"forks": 10}`,
+			err:  "failed to parse ansible config",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parseAnsibleConfig(tt.raw)
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(config, tt.want) {
+				t.Errorf("got %v, want %v", config, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAnsibleCfg(t *testing.T) {
+	content := `# leading comment
+orphan = dropped
+
+[defaults]
+inventory = hosts ; not a comment in ansible either
+roles_path = roles
+; comment
+forks: 20
+callbacks_enabled = timer,
+    profile_tasks
+
+[ssh_connection]
+pipelining=True
+[defaults]
+remote_user = deploy
+`
+
+	want := `[defaults]
+inventory = hosts ; not a comment in ansible either
+roles_path = roles
+forks = 20
+callbacks_enabled = timer,
+    profile_tasks
+remote_user = deploy
+
+[ssh_connection]
+pipelining = True
+`
+
+	if got := parseAnsibleCfg(content).String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestAnsibleConfigMerge(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(), map[string]string{
+		"ansible/ansible.cfg": "[defaults]\nroles_path = ./roles\nforks = 5\n\n[ssh_connection]\npipelining = True\n",
+	})
+
+	t.Setenv("ANSIBLE_CONFIG", filepath.Join(dir, "ansible", "ansible.cfg"))
+
+	config, err := parseAnsibleConfig("defaults:\n  forks: 20\n  callbacks_enabled: [timer, profile_tasks]\nprivilege_escalation:\n  become: true\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Plugin{stdout: io.Discard, stderr: io.Discard}
+	p.Config.AnsibleConfig = config
+	p.Config.DisableHostKeyChecking = true
+
+	if err := p.ansibleConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `[defaults]
+roles_path = ./roles
+forks = 20
+host_key_checking = False
+callbacks_enabled = timer,profile_tasks
+
+[ssh_connection]
+pipelining = True
+
+[privilege_escalation]
+become = true
+`
+
+	if p.Config.GeneratedConfig != want {
+		t.Errorf("got\n%s\nwant\n%s", p.Config.GeneratedConfig, want)
+	}
+
+	if want := filepath.Join(dir, "ansible"); p.Config.GeneratedConfigDir != want {
+		t.Errorf("got config dir %s, want %s", p.Config.GeneratedConfigDir, want)
+	}
+}
+
+func TestAnsibleConfigMissingBase(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("default", func(t *testing.T) {
+		t.Setenv("ANSIBLE_CONFIG", "")
+
+		wd, err := os.Getwd()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chdir(dir); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() { os.Chdir(wd) })
+
+		p := &Plugin{}
+		p.Config.DisableHostKeyChecking = true
+
+		if err := p.ansibleConfig(); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := "[defaults]\nhost_key_checking = False\n"; p.Config.GeneratedConfig != want {
+			t.Errorf("got\n%s\nwant\n%s", p.Config.GeneratedConfig, want)
+		}
+	})
+
+	t.Run("explicit", func(t *testing.T) {
+		t.Setenv("ANSIBLE_CONFIG", filepath.Join(dir, "missing.cfg"))
+
+		p := &Plugin{}
+		p.Config.DisableHostKeyChecking = true
+
+		if err := p.ansibleConfig(); err == nil || !os.IsNotExist(errors.Cause(err)) {
+			t.Errorf("got error %v, want not exist", err)
+		}
+	})
+}
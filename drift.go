@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// DriftReport lists the tasks which would change the hosts.
+	DriftReport struct {
+		Status    string        `json:"status"`
+		Threshold int           `json:"threshold"`
+		Tags      []string      `json:"tags,omitempty"`
+		Count     int           `json:"count"`
+		Tagged    int           `json:"tagged"`
+		Drift     []*DriftEntry `json:"drift"`
+	}
+
+	// DriftEntry is a single task which would change a host.
+	DriftEntry struct {
+		Inventory string   `json:"inventory"`
+		Play      string   `json:"play"`
+		Task      string   `json:"task"`
+		Host      string   `json:"host"`
+		Tags      []string `json:"tags,omitempty"`
+		Tagged    bool     `json:"tagged"`
+	}
+)
+
+// driftTask identifies a task by its play, as task names are only
+// unique per play.
+type driftTask struct {
+	play string
+	task string
+}
+
+var (
+	listPlayPattern = regexp.MustCompile(`^\s*play #\d+ \(.*?\): (.*?)\s+TAGS: \[.*\]$`)
+	listTaskPattern = regexp.MustCompile(`^\s+(.+?)\s+TAGS: \[(.*)\]$`)
+)
+
+// executeDrift runs the playbooks in check and diff mode and fails if the
+// detected drift exceeds the threshold or touches one of the drift tags.
+func (p *Plugin) executeDrift() error {
+	p.Config.Check = true
+	p.Config.Diff = true
+
+	// drift is only detected by a regular run of the playbooks
+	p.Config.ListHosts = false
+	p.Config.ListTags = false
+	p.Config.ListTasks = false
+	p.Config.SyntaxCheck = false
+
+	if err := p.preparePlaybook(); err != nil {
+		return err
+	}
+
+	var tags map[driftTask][]string
+
+	if len(p.Config.DriftTags) > 0 {
+		var err error
+
+		if tags, err = p.taskTags(); err != nil {
+			return err
+		}
+	}
+
+	var runErr error
+
+	for _, inventory := range p.Config.Inventories {
+		result, err := p.runPlaybook(inventory, nil, p.stdout, p.stderr)
+		p.Results = append(p.Results, result)
+
+		if err != nil {
+			runErr = err
+			break
+		}
+	}
+
+	if p.Config.DryRun {
+		return runErr
+	}
+
+	report := p.driftReport(tags)
+	printDrift(p.stdout, report)
+
+	if p.Config.DriftReport != "" {
+		content, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "failed to encode drift report")
+		}
+
+		if err := os.WriteFile(p.Config.DriftReport, content, 0644); err != nil {
+			return errors.Wrap(err, "failed to write drift report")
+		}
+	}
+
+	if runErr != nil {
+		return errors.Wrap(runErr, "check run failed")
+	}
+
+	if report.Status != "success" {
+		return fmt.Errorf("detected drift in %d tasks, %d of them tagged %s", report.Count, report.Tagged, strings.Join(p.Config.DriftTags, ","))
+	}
+
+	return nil
+}
+
+// driftReport collects the changed tasks of all runs and decides whether
+// the drift is acceptable.
+func (p *Plugin) driftReport(tags map[driftTask][]string) *DriftReport {
+	report := &DriftReport{
+		Threshold: p.Config.DriftThreshold,
+		Tags:      p.Config.DriftTags,
+		Drift:     []*DriftEntry{},
+	}
+
+	for _, result := range p.Results {
+		for _, play := range result.Plays {
+			for _, task := range play.Tasks {
+				if task.Status != TaskChanged {
+					continue
+				}
+
+				entry := &DriftEntry{
+					Inventory: result.Inventory,
+					Play:      play.Name,
+					Task:      task.Name,
+					Host:      task.Host,
+					Tags:      tags[driftTask{play: play.Name, task: task.Name}],
+				}
+
+				if entry.Tagged = matchTags(entry.Tags, p.Config.DriftTags); entry.Tagged {
+					report.Tagged++
+				}
+
+				report.Drift = append(report.Drift, entry)
+			}
+		}
+	}
+
+	report.Count = len(report.Drift)
+
+	// a negative threshold only fails on tagged drift
+	exceeded := p.Config.DriftThreshold >= 0 && report.Count > p.Config.DriftThreshold
+	report.Status = status(!exceeded && report.Tagged == 0)
+
+	return report
+}
+
+// taskTags lists the tasks of the playbooks with their effective tags.
+func (p *Plugin) taskTags() (map[driftTask][]string, error) {
+	var stdout bytes.Buffer
+
+	config := p.Config
+	config.ListTasks = true
+
+	cmd, err := p.command(config.playbookCommand(config.Inventories[0], nil))
+	if err != nil {
+		return nil, err
+	}
+
+	cmd.Stdout = &stdout
+	cmd.Stderr = p.stderr
+
+	trace(p.stdout, cmd)
+
+	if err := p.runCommand(cmd); err != nil {
+		return nil, errors.Wrap(err, "failed to list tasks")
+	}
+
+	return parseTaskTags(stdout.String()), nil
+}
+
+// parseTaskTags reads the tags of every task from the --list-tasks output.
+func parseTaskTags(output string) map[driftTask][]string {
+	var (
+		tags = map[driftTask][]string{}
+		play string
+	)
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		if m := listPlayPattern.FindStringSubmatch(line); m != nil {
+			play = m[1]
+			continue
+		}
+
+		m := listTaskPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		key := driftTask{play: play, task: m[1]}
+
+		for _, tag := range strings.Split(m[2], ",") {
+			if tag = strings.TrimSpace(tag); tag != "" && !slices.Contains(tags[key], tag) {
+				tags[key] = append(tags[key], tag)
+			}
+		}
+	}
+
+	return tags
+}
+
+func matchTags(tags, match []string) bool {
+	for _, tag := range tags {
+		for _, m := range match {
+			if tag == m {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// printDrift writes a table of the drifted tasks.
+func printDrift(w io.Writer, report *DriftReport) {
+	fmt.Fprintln(w)
+
+	if report.Count == 0 {
+		fmt.Fprintln(w, "No drift detected")
+		return
+	}
+
+	fmt.Fprintln(w, "Drift:")
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "INVENTORY\tHOST\tPLAY\tTASK\tTAGS")
+
+	for _, d := range report.Drift {
+		name := d.Task
+		if d.Tagged {
+			name += " (tagged)"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", d.Inventory, d.Host, d.Play, name, strings.Join(d.Tags, ","))
+	}
+
+	tw.Flush()
+
+	fmt.Fprintf(w, "Detected drift in %d tasks, threshold %d\n", report.Count, report.Threshold)
+}
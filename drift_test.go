@@ -0,0 +1,140 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const (
+	listTasksOutput = `
+playbook: site.yml
+
+  play #1 (web): Configure web	TAGS: []
+    tasks:
+      nginx : install nginx	TAGS: [nginx, packages]
+      configure	TAGS: [config]
+
+  play #2 (db): Configure db	TAGS: [db]
+    tasks:
+      configure	TAGS: [db, db]
+      restart	TAGS: [db]
+
+  play #3 (all): all	TAGS: []
+    tasks:
+      ping	TAGS: []
+`
+
+	driftOutput = `
+PLAY [Configure web] ***********************************************************
+
+TASK [nginx : install nginx] ***************************************************
+ok: [web1]
+
+TASK [configure] ***************************************************************
+changed: [web1]
+
+PLAY [Configure db] ************************************************************
+
+TASK [configure] ***************************************************************
+changed: [db1]
+
+TASK [restart] *****************************************************************
+ok: [db1]
+
+PLAY RECAP *********************************************************************
+db1                        : ok=2    changed=1    unreachable=0    failed=0    skipped=0    rescued=0    ignored=0
+web1                       : ok=2    changed=1    unreachable=0    failed=0    skipped=0    rescued=0    ignored=0
+`
+)
+
+func TestParseTaskTags(t *testing.T) {
+	want := map[driftTask][]string{
+		{play: "Configure web", task: "nginx : install nginx"}: {"nginx", "packages"},
+		{play: "Configure web", task: "configure"}:             {"config"},
+		{play: "Configure db", task: "configure"}:              {"db"},
+		{play: "Configure db", task: "restart"}:                {"db"},
+	}
+
+	if got := parseTaskTags(listTasksOutput); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestListTaskPattern(t *testing.T) {
+	tests := []struct {
+		line string
+		task string
+		tags string
+	}{
+		{"      configure\tTAGS: [config]", "configure", "config"},
+		{"      nginx : install nginx\tTAGS: [nginx, packages]", "nginx : install nginx", "nginx, packages"},
+		{"      template [x]   TAGS: []", "template [x]", ""},
+		{"    tasks:", "", ""},
+		{"playbook: site.yml", "", ""},
+	}
+
+	for _, tt := range tests {
+		var task, tags string
+		if m := listTaskPattern.FindStringSubmatch(tt.line); m != nil {
+			task, tags = m[1], m[2]
+		}
+
+		if task != tt.task || tags != tt.tags {
+			t.Errorf("%q matched task %q with tags %q, want %q with %q", tt.line, task, tags, tt.task, tt.tags)
+		}
+	}
+}
+
+func TestDriftReport(t *testing.T) {
+	tags := parseTaskTags(listTasksOutput)
+
+	tests := []struct {
+		name      string
+		threshold int
+		tags      []string
+		status    string
+		tagged    int
+	}{
+		{"within threshold", 2, nil, "success", 0},
+		{"exceeds threshold", 1, nil, "failure", 0},
+		{"negative threshold", -1, nil, "success", 0},
+		{"tagged", 5, []string{"config"}, "failure", 1},
+		{"tagged with negative threshold", -1, []string{"db"}, "failure", 1},
+		{"tag of other play", -1, []string{"packages"}, "success", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Plugin{
+				Config: Config{
+					DriftThreshold: tt.threshold,
+					DriftTags:      tt.tags,
+				},
+				Results: []*RunResult{parseOutput("hosts", driftOutput)},
+			}
+
+			report := p.driftReport(tags)
+
+			if report.Count != 2 || report.Tagged != tt.tagged || report.Status != tt.status {
+				t.Errorf("got %d changed, %d tagged with status %s, want 2 changed, %d tagged with status %s", report.Count, report.Tagged, report.Status, tt.tagged, tt.status)
+			}
+		})
+	}
+
+	p := &Plugin{
+		Config:  Config{DriftThreshold: -1, DriftTags: []string{"db"}},
+		Results: []*RunResult{parseOutput("hosts", driftOutput)},
+	}
+
+	var got []string
+	for _, entry := range p.driftReport(tags).Drift {
+		if entry.Tagged {
+			got = append(got, entry.Play+"/"+entry.Task+"@"+entry.Host)
+		}
+	}
+
+	// the web configure task shares its name with the tagged db one
+	if want := []string{"Configure db/configure@db1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got tagged drift %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// dryRunPrefix marks every line describing a command which was not run.
+const dryRunPrefix = "[dry-run]"
+
+// dryRun prints the command plan instead of running it: the command, the
+// environment on top of the process environment, the temporary files
+// created for it and the generated ansible.cfg. The output passes the
+// mask writer, so secrets are not revealed.
+func (p *Plugin) dryRun(cmd *exec.Cmd) {
+	fmt.Fprintln(p.stdout, dryRunPrefix, "would run:", strings.Join(cmd.Args, " "))
+
+	if cmd.Dir != "" {
+		fmt.Fprintln(p.stdout, dryRunPrefix, "  dir:", cmd.Dir)
+	}
+
+	inherited := map[string]bool{}
+	for _, env := range os.Environ() {
+		inherited[env] = true
+	}
+
+	for _, env := range cmd.Env {
+		if !inherited[env] {
+			fmt.Fprintln(p.stdout, dryRunPrefix, "  env:", env)
+		}
+	}
+
+	p.tempMu.Lock()
+	files := p.tempFiles[p.dryRunFiles:]
+	shown := make([]bool, len(files))
+	for i, file := range files {
+		shown[i] = p.shownFiles[file]
+	}
+	p.dryRunFiles = len(p.tempFiles)
+	p.tempMu.Unlock()
+
+	for i, file := range files {
+		fmt.Fprintln(p.stdout, dryRunPrefix, "  file:", file)
+
+		if shown[i] {
+			p.printDryRunFile(file)
+		}
+	}
+}
+
+// printDryRunFile prints the content of a generated file.
+func (p *Plugin) printDryRunFile(path string) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(p.stdout, dryRunPrefix, "    failed to read:", err)
+		return
+	}
+
+	for _, line := range strings.Split(strings.TrimRight(string(content), "\n"), "\n") {
+		fmt.Fprintln(p.stdout, dryRunPrefix, "   ", line)
+	}
+}
+
+// dryRunVault prints the files the native vault implementation would
+// process.
+func (p *Plugin) dryRunVault(files []string) {
+	if p.Config.Action == ActionEncryptString {
+		files = []string{"content"}
+	}
+
+	for _, file := range files {
+		line := []interface{}{dryRunPrefix, "would", p.Config.Action, file}
+
+		if p.Config.Output != "" && p.Config.Action != ActionView && p.Config.Action != ActionVerify {
+			line = append(line, "to", p.Config.Output)
+		}
+
+		fmt.Fprintln(p.stdout, line...)
+	}
+}
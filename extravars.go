@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	keyValuePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+	mappingPattern  = regexp.MustCompile(`^["']?[\w.-]+["']?:(\s|$)`)
+)
+
+// parseExtraVars splits the extra_vars setting into a YAML or JSON object
+// and the list of key=value pairs it was before. Input starting like a
+// key=value pair or a vars file is always a list, input looking like an
+// object has to be a valid one.
+func parseExtraVars(raw string) (map[string]interface{}, []string, error) {
+	trimmed := strings.TrimSpace(raw)
+	if trimmed == "" {
+		return nil, nil, nil
+	}
+
+	if keyValuePattern.MatchString(trimmed) || strings.HasPrefix(trimmed, "@") {
+		return nil, splitExtraVars(trimmed), nil
+	}
+
+	var node yaml.Node
+
+	if err := yaml.Unmarshal([]byte(trimmed), &node); err != nil {
+		if strings.HasPrefix(trimmed, "{") || mappingPattern.MatchString(trimmed) {
+			return nil, nil, errors.Wrap(err, "failed to parse extra vars")
+		}
+
+		return nil, splitExtraVars(trimmed), nil
+	}
+
+	if len(node.Content) == 0 || node.Content[0].Kind != yaml.MappingNode {
+		return nil, splitExtraVars(trimmed), nil
+	}
+
+	vars := map[string]interface{}{}
+	if err := node.Decode(&vars); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse extra vars")
+	}
+
+	return vars, nil, nil
+}
+
+// splitExtraVars splits key=value extra vars on commas. A comma only starts
+// a new entry if a key or a vars file follows, other commas are part of
+// the value.
+func splitExtraVars(raw string) []string {
+	var list []string
+
+	for _, segment := range strings.Split(raw, ",") {
+		trimmed := strings.TrimSpace(segment)
+
+		if len(list) > 0 && !keyValuePattern.MatchString(trimmed) && !strings.HasPrefix(trimmed, "@") {
+			list[len(list)-1] += "," + segment
+			continue
+		}
+
+		list = append(list, segment)
+	}
+
+	var vars []string
+
+	for _, ev := range list {
+		if ev = strings.TrimSpace(ev); ev != "" {
+			vars = append(vars, ev)
+		}
+	}
+
+	return vars
+}
+
+// loadExtraVars merges the extra vars files into the extra vars object.
+// Files override the ones before them and the object overrides all files,
+// key=value extra vars are passed afterwards and take precedence over both.
+func (p *Plugin) loadExtraVars() error {
+	if len(p.Config.ExtraVarsFiles) == 0 && len(p.Config.ExtraVarsMap) == 0 {
+		return nil
+	}
+
+	vars := map[string]interface{}{}
+
+	for _, path := range p.Config.ExtraVarsFiles {
+		file, err := readVarsFile(strings.TrimPrefix(path, "@"))
+		if err != nil {
+			return err
+		}
+
+		for key, value := range file {
+			vars[key] = value
+		}
+	}
+
+	for key, value := range p.Config.ExtraVarsMap {
+		vars[key] = value
+	}
+
+	// the commands pass the merged object as JSON
+	if _, err := json.Marshal(vars); err != nil {
+		return errors.Wrap(err, "failed to encode extra vars")
+	}
+
+	p.Config.ExtraVarsMap = vars
+
+	return nil
+}
+
+// readVarsFile reads a YAML or JSON file holding an object of variables.
+func readVarsFile(path string) (map[string]interface{}, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read extra vars file %s", path)
+	}
+
+	vars := map[string]interface{}{}
+
+	if err := yaml.Unmarshal(content, &vars); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse extra vars file %s", path)
+	}
+
+	return vars, nil
+}
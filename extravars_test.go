@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseExtraVars(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		vars map[string]interface{}
+		list []string
+		err  bool
+	}{
+		{name: "empty", raw: "  "},
+		{name: "json", raw: `{"a": 1, "b": "x"}`, vars: map[string]interface{}{"a": 1, "b": "x"}},
+		{name: "yaml", raw: "a: 1\nb: [x, y]\n", vars: map[string]interface{}{"a": 1, "b": []interface{}{"x", "y"}}},
+		{name: "key value", raw: "a=1, b=2", list: []string{"a=1", "b=2"}},
+		{name: "key value with colon", raw: "msg=hi: there", list: []string{"msg=hi: there"}},
+		{name: "key value with comma", raw: "msg=a,b", list: []string{"msg=a,b"}},
+		{name: "key value with commas", raw: "msg=a, b,c, d=1,@vars.yml", list: []string{"msg=a, b,c", "d=1", "@vars.yml"}},
+		{name: "key value trailing comma", raw: "a=1,", list: []string{"a=1,"}},
+		{name: "key value with json", raw: `data={"x": 1}`, list: []string{`data={"x": 1}`}},
+		{name: "vars file", raw: "@vars.yml,a=1", list: []string{"@vars.yml", "a=1"}},
+		{name: "scalar", raw: "foo", list: []string{"foo"}},
+		{name: "malformed json", raw: `{"a": 1`, err: true},
+		{name: "malformed yaml", raw: "a: [1\nb: 2", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars, list, err := parseExtraVars(tt.raw)
+
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v and %v", vars, list)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(vars, tt.vars) {
+				t.Errorf("got vars %#v, want %#v", vars, tt.vars)
+			}
+
+			if !reflect.DeepEqual(list, tt.list) {
+				t.Errorf("got list %#v, want %#v", list, tt.list)
+			}
+		})
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// GalaxyRequirements are the roles and collections of a galaxy
+	// requirements file.
+	GalaxyRequirements struct {
+		Roles       []*GalaxyRequirement `yaml:"roles,omitempty"`
+		Collections []*GalaxyRequirement `yaml:"collections,omitempty"`
+	}
+
+	// GalaxyRequirement is a single role or collection.
+	GalaxyRequirement struct {
+		Name    string `yaml:"name,omitempty"`
+		Src     string `yaml:"src,omitempty"`
+		Version string `yaml:"version,omitempty"`
+		Source  string `yaml:"source,omitempty"`
+		Type    string `yaml:"type,omitempty"`
+		Scm     string `yaml:"scm,omitempty"`
+	}
+)
+
+// UnmarshalYAML accepts the short form of a requirement, just its name.
+func (r *GalaxyRequirement) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		r.Name = value.Value
+		return nil
+	}
+
+	type plain GalaxyRequirement
+	return value.Decode((*plain)(r))
+}
+
+// RoleName returns the name the role is installed as.
+func (r *GalaxyRequirement) RoleName() string {
+	if r.Name != "" {
+		return r.Name
+	}
+
+	// roles from scm are named after their repository
+	name := strings.TrimSuffix(r.Src, "/")
+	if i := strings.LastIndexAny(name, "/:"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	name = strings.TrimSuffix(name, ".git")
+	name = strings.TrimSuffix(name, ".tar.gz")
+
+	return strings.TrimPrefix(name, "ansible-role-")
+}
+
+// parseGalaxyRequirements reads a galaxy requirements file, either the
+// list of roles or the mapping of roles and collections.
+func parseGalaxyRequirements(path string) (*GalaxyRequirements, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read galaxy requirements")
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal(content, &node); err != nil {
+		return nil, errors.Wrap(err, "failed to parse galaxy requirements")
+	}
+
+	req := &GalaxyRequirements{}
+
+	if len(node.Content) == 0 {
+		return req, nil
+	}
+
+	root := node.Content[0]
+
+	switch root.Kind {
+	case yaml.SequenceNode:
+		err = root.Decode(&req.Roles)
+	case yaml.MappingNode:
+		err = root.Decode(req)
+	default:
+		err = errors.New("expected a list of roles or a mapping of roles and collections")
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse galaxy requirements")
+	}
+
+	return req, nil
+}
+
+// requirementsFile writes generated galaxy requirements to a temporary file.
+func (p *Plugin) requirementsFile(req interface{}) (string, error) {
+	content, err := yaml.Marshal(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode galaxy requirements")
+	}
+
+	tmpfile, err := os.CreateTemp("", "requirements-*.yml")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create galaxy requirements")
+	}
+
+	p.removeLater(tmpfile.Name())
+
+	if _, err := tmpfile.Write(content); err != nil {
+		tmpfile.Close()
+		return "", errors.Wrap(err, "failed to write galaxy requirements")
+	}
+
+	if err := tmpfile.Close(); err != nil {
+		return "", errors.Wrap(err, "failed to close galaxy requirements")
+	}
+
+	return tmpfile.Name(), nil
+}
+
+// installGalaxy installs the roles and collections of the galaxy
+// requirements file, or reuses them from the cache. With a lockfile the
+// installed versions are recorded, or in enforce mode exactly the locked
+// versions are installed and verified.
+func (p *Plugin) installGalaxy() error {
+	req, err := parseGalaxyRequirements(p.Config.Galaxy)
+	if err != nil {
+		return err
+	}
+
+	var lock *GalaxyLock
+
+	if p.Config.GalaxyLock != "" && p.Config.GalaxyLockMode == GalaxyLockEnforce {
+		if lock, err = readGalaxyLock(p.Config.GalaxyLock); err != nil {
+			return err
+		}
+
+		if err := lock.check(p.Config.GalaxyLock, req); err != nil {
+			return err
+		}
+
+		if req, err = p.lockedRequirements(lock); err != nil {
+			return err
+		}
+	}
+
+	if p.Config.GalaxyCacheDir != "" {
+		err = p.installGalaxyCached(req)
+	} else {
+		err = p.galaxyInstall(req)
+	}
+
+	if err != nil || p.Config.GalaxyLock == "" || p.Config.DryRun {
+		return err
+	}
+
+	if lock != nil {
+		return p.verifyGalaxyLock(lock)
+	}
+
+	return p.writeGalaxyLock(req)
+}
+
+// installGalaxyCached reuses the roles and collections installed by an
+// earlier build with the same requirements and ansible version. Otherwise
+// they are installed into a fresh directory which is renamed into place,
+// so an interrupted install never leaves a partial cache entry behind.
+func (p *Plugin) installGalaxyCached(req *GalaxyRequirements) error {
+	key, err := p.galaxyCacheKey()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(p.Config.GalaxyCacheDir, key)
+
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		fmt.Fprintf(p.stdout, "Using cached galaxy requirements from %s\n", dir)
+
+		p.useGalaxyDir(dir)
+		p.galaxyReport(req)
+
+		return nil
+	}
+
+	if p.Config.DryRun {
+		p.useGalaxyDir(dir)
+		return p.galaxyInstall(req)
+	}
+
+	if err := os.MkdirAll(p.Config.GalaxyCacheDir, 0755); err != nil {
+		return errors.Wrap(err, "failed to create galaxy cache directory")
+	}
+
+	tmp, err := os.MkdirTemp(p.Config.GalaxyCacheDir, ".install-")
+	if err != nil {
+		return errors.Wrap(err, "failed to create galaxy install directory")
+	}
+
+	p.useGalaxyDir(tmp)
+
+	if err := p.galaxyInstall(req); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, dir); err != nil {
+		os.RemoveAll(tmp)
+
+		// a concurrent build may have populated the entry first
+		if info, statErr := os.Stat(dir); statErr != nil || !info.IsDir() {
+			return errors.Wrap(err, "failed to store galaxy cache")
+		}
+	}
+
+	fmt.Fprintf(p.stdout, "Stored galaxy requirements in %s\n", dir)
+	p.useGalaxyDir(dir)
+
+	return nil
+}
+
+// galaxyCacheKey hashes the requirements file and the ansible release.
+func (p *Plugin) galaxyCacheKey() (string, error) {
+	content, err := os.ReadFile(p.Config.Galaxy)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read galaxy requirements")
+	}
+
+	var version bytes.Buffer
+
+	cmd, err := p.command(p.Config.versionCommand())
+	if err != nil {
+		return "", err
+	}
+
+	cmd.Stdout = &version
+	cmd.Stderr = p.stderr
+
+	if err := p.runCommand(cmd); err != nil {
+		return "", errors.Wrap(err, "failed to determine the ansible version")
+	}
+
+	hash := sha256.New()
+	hash.Write(content)
+	hash.Write([]byte{0})
+	hash.Write([]byte(ansibleRelease(version.String())))
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ansibleRelease extracts the release line like "ansible [core 2.15.3]"
+// from the output of ansible --version. The remaining lines contain paths
+// like the config file and the executable location which differ between
+// builds and would invalidate the cache.
+func ansibleRelease(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+
+	return ""
+}
+
+// useGalaxyDir installs to and loads the roles and collections from dir.
+func (p *Plugin) useGalaxyDir(dir string) {
+	// keep the search paths independent of the working directory
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+
+	p.Config.GalaxyRolesPath = filepath.Join(dir, "roles")
+	p.Config.GalaxyCollectionsPath = filepath.Join(dir, "collections")
+}
+
+// galaxyInstall runs ansible-galaxy for the roles and collections and
+// reports what got installed. With an offline directory the requirements
+// are installed from the vendored tarballs instead.
+func (p *Plugin) galaxyInstall(req *GalaxyRequirements) error {
+	config := p.Config
+
+	if p.Config.GalaxyOfflineDir != "" {
+		path, err := p.offlineRequirements(req)
+		if err != nil {
+			return err
+		}
+
+		config.Galaxy = path
+		config.GalaxyOffline = true
+	}
+
+	for _, plan := range config.galaxyCommands(req) {
+		cmd, err := p.command(plan)
+		if err != nil {
+			return err
+		}
+
+		cmd.Stdout = p.stdout
+		cmd.Stderr = p.stderr
+
+		trace(p.stdout, cmd)
+
+		if err := p.runCommand(cmd); err != nil {
+			return err
+		}
+	}
+
+	if !p.Config.DryRun {
+		p.galaxyReport(req)
+	}
+
+	return nil
+}
+
+// galaxyReport prints the requested and the installed version of every
+// role and collection.
+func (p *Plugin) galaxyReport(req *GalaxyRequirements) {
+	if len(req.Roles) == 0 && len(req.Collections) == 0 {
+		return
+	}
+
+	fmt.Fprintln(p.stdout)
+	fmt.Fprintln(p.stdout, "Galaxy requirements:")
+
+	tw := tabwriter.NewWriter(p.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tNAME\tREQUESTED\tINSTALLED")
+
+	for _, r := range req.Roles {
+		name := r.RoleName()
+		fmt.Fprintf(tw, "role\t%s\t%s\t%s\n", name, orDash(r.Version), orDash(roleVersion(p.Config.galaxyRolesPath(), name)))
+	}
+
+	for _, c := range req.Collections {
+		fmt.Fprintf(tw, "collection\t%s\t%s\t%s\n", c.Name, orDash(c.Version), orDash(collectionVersion(p.Config.galaxyCollectionsPath(), c.Name)))
+	}
+
+	tw.Flush()
+}
+
+// roleVersion reads the version of an installed role from the install
+// info ansible-galaxy leaves behind.
+func roleVersion(path, name string) string {
+	content, err := os.ReadFile(filepath.Join(path, name, "meta", ".galaxy_install_info"))
+	if err != nil {
+		return ""
+	}
+
+	info := struct {
+		Version string `yaml:"version"`
+	}{}
+
+	if err := yaml.Unmarshal(content, &info); err != nil {
+		return ""
+	}
+
+	if info.Version == "" {
+		return "installed"
+	}
+
+	return info.Version
+}
+
+// collectionVersion reads the version of an installed collection from its
+// manifest.
+func collectionVersion(path, name string) string {
+	parts := strings.SplitN(name, ".", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+
+	content, err := os.ReadFile(filepath.Join(path, "ansible_collections", parts[0], parts[1], "MANIFEST.json"))
+	if err != nil {
+		return ""
+	}
+
+	manifest := struct {
+		CollectionInfo struct {
+			Version string `json:"version"`
+		} `json:"collection_info"`
+	}{}
+
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return ""
+	}
+
+	return manifest.CollectionInfo.Version
+}
+
+// galaxyRolesPath returns the directory roles get installed to.
+func (c Config) galaxyRolesPath() string {
+	if c.GalaxyRolesPath != "" {
+		return c.GalaxyRolesPath
+	}
+
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ansible", "roles")
+}
+
+// galaxyCollectionsPath returns the directory collections get installed to.
+func (c Config) galaxyCollectionsPath() string {
+	if c.GalaxyCollectionsPath != "" {
+		return c.GalaxyCollectionsPath
+	}
+
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ansible", "collections")
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestAnsibleRelease(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name: "core",
+			output: `ansible [core 2.15.3]
+  config file = /drone/src/ansible.cfg
+  configured module search path = ['/root/.ansible/plugins/modules']
+  ansible python module location = /usr/lib/python3/dist-packages/ansible
+  executable location = /usr/bin/ansible
+  python version = 3.11.4
+`,
+			want: "ansible [core 2.15.3]",
+		},
+		{
+			name:   "legacy",
+			output: "\nansible 2.9.27\n  config file = None\n",
+			want:   "ansible 2.9.27",
+		},
+		{
+			name:   "empty",
+			output: "",
+			want:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ansibleRelease(tt.output); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
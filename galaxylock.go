@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Galaxy lock modes
+const (
+	GalaxyLockUpdate  = "update"
+	GalaxyLockEnforce = "enforce"
+)
+
+type (
+	// GalaxyLock records the roles and collections installed for a galaxy
+	// requirements file.
+	GalaxyLock struct {
+		Roles       []*GalaxyLockEntry `yaml:"roles,omitempty"`
+		Collections []*GalaxyLockEntry `yaml:"collections,omitempty"`
+	}
+
+	// GalaxyLockEntry is a single installed role or collection together
+	// with the requirement it was installed for.
+	GalaxyLockEntry struct {
+		Name      string             `yaml:"name"`
+		Version   string             `yaml:"version,omitempty"`
+		Source    string             `yaml:"source"`
+		Checksum  string             `yaml:"checksum,omitempty"`
+		Requested *GalaxyRequirement `yaml:"requested"`
+	}
+)
+
+// readGalaxyLock reads the lockfile.
+func readGalaxyLock(path string) (*GalaxyLock, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read galaxy lock")
+	}
+
+	lock := &GalaxyLock{}
+
+	if err := yaml.Unmarshal(content, lock); err != nil {
+		return nil, errors.Wrap(err, "failed to parse galaxy lock")
+	}
+
+	for _, entry := range append(append([]*GalaxyLockEntry{}, lock.Roles...), lock.Collections...) {
+		if entry == nil || entry.Name == "" {
+			return nil, errors.New("failed to parse galaxy lock: entry without name")
+		}
+	}
+
+	return lock, nil
+}
+
+// requirement returns the requirement the entry was installed for. Hand
+// edited locks may lack it, the entry name is used then.
+func (e *GalaxyLockEntry) requirement() *GalaxyRequirement {
+	if e.Requested != nil {
+		return e.Requested
+	}
+	return &GalaxyRequirement{Name: e.Name}
+}
+
+// check reports every requirement which was added, removed or changed
+// since the lock was written.
+func (l *GalaxyLock) check(path string, req *GalaxyRequirements) error {
+	var drift []string
+
+	compare := func(kind string, entries []*GalaxyLockEntry, reqs []*GalaxyRequirement, name func(*GalaxyRequirement) string) {
+		locked := map[string]*GalaxyLockEntry{}
+		for _, entry := range entries {
+			locked[entry.Name] = entry
+		}
+
+		for _, r := range reqs {
+			entry, ok := locked[name(r)]
+			delete(locked, name(r))
+
+			switch {
+			case !ok:
+				drift = append(drift, fmt.Sprintf("%s %s is not locked", kind, name(r)))
+			case *entry.requirement() != *r:
+				drift = append(drift, fmt.Sprintf("%s %s changed since it was locked", kind, name(r)))
+			}
+		}
+
+		for _, entry := range entries {
+			if _, ok := locked[entry.Name]; ok {
+				drift = append(drift, fmt.Sprintf("%s %s was removed from the requirements", kind, entry.Name))
+			}
+		}
+	}
+
+	compare("role", l.Roles, req.Roles, (*GalaxyRequirement).RoleName)
+	compare("collection", l.Collections, req.Collections, func(r *GalaxyRequirement) string { return r.Name })
+
+	if len(drift) > 0 {
+		return fmt.Errorf("galaxy requirements drifted from %s:\n  %s", path, strings.Join(drift, "\n  "))
+	}
+
+	return nil
+}
+
+// lockedRequirements pins every requirement to its locked version and
+// installs from a requirements file holding them.
+func (p *Plugin) lockedRequirements(lock *GalaxyLock) (*GalaxyRequirements, error) {
+	req := &GalaxyRequirements{}
+
+	pin := func(entry *GalaxyLockEntry) *GalaxyRequirement {
+		r := *entry.requirement()
+		if entry.Version != "" {
+			r.Version = entry.Version
+		}
+		return &r
+	}
+
+	for _, entry := range lock.Roles {
+		req.Roles = append(req.Roles, pin(entry))
+	}
+
+	for _, entry := range lock.Collections {
+		req.Collections = append(req.Collections, pin(entry))
+	}
+
+	path, err := p.requirementsFile(req)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Config.Galaxy = path
+
+	return req, nil
+}
+
+// writeGalaxyLock records the installed version, source and checksum of
+// every role and collection.
+func (p *Plugin) writeGalaxyLock(req *GalaxyRequirements) error {
+	lock := &GalaxyLock{}
+
+	for _, r := range req.Roles {
+		lock.Roles = append(lock.Roles, p.roleLockEntry(r))
+	}
+
+	for _, c := range req.Collections {
+		lock.Collections = append(lock.Collections, p.collectionLockEntry(c))
+	}
+
+	content, err := yaml.Marshal(lock)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode galaxy lock")
+	}
+
+	if err := os.WriteFile(p.Config.GalaxyLock, content, 0644); err != nil {
+		return errors.Wrap(err, "failed to write galaxy lock")
+	}
+
+	fmt.Fprintf(p.stdout, "Wrote galaxy lock to %s\n", p.Config.GalaxyLock)
+
+	return nil
+}
+
+// verifyGalaxyLock fails unless the installed roles and collections match
+// the locked versions and checksums.
+func (p *Plugin) verifyGalaxyLock(lock *GalaxyLock) error {
+	var mismatch []string
+
+	verify := func(kind string, entry, installed *GalaxyLockEntry) {
+		if entry.Version != "" && entry.Version != installed.Version {
+			mismatch = append(mismatch, fmt.Sprintf("%s %s: locked version %s, installed %s", kind, entry.Name, entry.Version, orDash(installed.Version)))
+		}
+
+		if entry.Checksum != "" && entry.Checksum != installed.Checksum {
+			mismatch = append(mismatch, fmt.Sprintf("%s %s: locked checksum %s, installed %s", kind, entry.Name, entry.Checksum, orDash(installed.Checksum)))
+		}
+	}
+
+	for _, entry := range lock.Roles {
+		verify("role", entry, p.roleLockEntry(entry.requirement()))
+	}
+
+	for _, entry := range lock.Collections {
+		verify("collection", entry, p.collectionLockEntry(entry.requirement()))
+	}
+
+	if len(mismatch) > 0 {
+		return fmt.Errorf("installed galaxy requirements do not match %s:\n  %s", p.Config.GalaxyLock, strings.Join(mismatch, "\n  "))
+	}
+
+	fmt.Fprintf(p.stdout, "Verified galaxy requirements against %s\n", p.Config.GalaxyLock)
+
+	return nil
+}
+
+// roleLockEntry describes an installed role.
+func (p *Plugin) roleLockEntry(r *GalaxyRequirement) *GalaxyLockEntry {
+	name := r.RoleName()
+	source := p.galaxySource()
+
+	if r.Scm != "" || strings.ContainsAny(r.Src, "/:") {
+		source = r.Src
+
+		if r.Scm != "" && !strings.HasPrefix(source, r.Scm+"+") {
+			source = r.Scm + "+" + source
+		}
+	}
+
+	version := roleVersion(p.Config.galaxyRolesPath(), name)
+
+	// roles without a version only record that they got installed
+	if version == "installed" {
+		version = ""
+	}
+
+	return &GalaxyLockEntry{
+		Name:      name,
+		Version:   version,
+		Source:    source,
+		Checksum:  treeChecksum(filepath.Join(p.Config.galaxyRolesPath(), name)),
+		Requested: r,
+	}
+}
+
+// collectionLockEntry describes an installed collection.
+func (p *Plugin) collectionLockEntry(c *GalaxyRequirement) *GalaxyLockEntry {
+	entry := &GalaxyLockEntry{
+		Name:      c.Name,
+		Source:    p.galaxySource(),
+		Requested: c,
+	}
+
+	switch {
+	case c.Type == "git" || c.Type == "url" || c.Type == "file" || c.Type == "dir":
+		entry.Source = c.Name
+	case c.Source != "":
+		entry.Source = c.Source
+	}
+
+	if parts := strings.SplitN(c.Name, ".", 2); len(parts) == 2 && !strings.ContainsAny(c.Name, "/:") {
+		entry.Version = collectionVersion(p.Config.galaxyCollectionsPath(), c.Name)
+		entry.Checksum = treeChecksum(filepath.Join(p.Config.galaxyCollectionsPath(), "ansible_collections", parts[0], parts[1]))
+	}
+
+	return entry
+}
+
+// galaxySource returns where roles and collections from galaxy come from.
+func (p *Plugin) galaxySource() string {
+	switch {
+	case p.Config.GalaxyOfflineDir != "":
+		return p.Config.GalaxyOfflineDir
+	case p.Config.GalaxyServer != "":
+		return p.Config.GalaxyServer
+	default:
+		return "https://galaxy.ansible.com"
+	}
+}
+
+// treeChecksum hashes the paths and contents of all files below dir. The
+// install info of roles is skipped as it holds the install date.
+func treeChecksum(dir string) string {
+	hash := sha256.New()
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || entry.Name() == ".galaxy_install_info" {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(hash, "%s\x00", filepath.ToSlash(rel))
+
+		if entry.Type()&fs.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(hash, "-> %s\x00", target)
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if _, err := io.Copy(hash, file); err != nil {
+			return err
+		}
+
+		hash.Write([]byte{0})
+		return nil
+	})
+
+	if err != nil {
+		return ""
+	}
+
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testGalaxyLock locks a role from galaxy, a role from git and a collection.
+func testGalaxyLock() *GalaxyLock {
+	return &GalaxyLock{
+		Roles: []*GalaxyLockEntry{
+			{
+				Name:      "geerlingguy.docker",
+				Version:   "7.0.0",
+				Source:    "https://galaxy.ansible.com",
+				Requested: &GalaxyRequirement{Name: "geerlingguy.docker", Version: ">=7.0.0"},
+			},
+			{
+				Name:      "nginx",
+				Version:   "v1.2.0",
+				Source:    "git+https://github.com/acme/ansible-role-nginx.git",
+				Requested: &GalaxyRequirement{Src: "https://github.com/acme/ansible-role-nginx.git", Scm: "git", Version: "v1.2.0"},
+			},
+		},
+		Collections: []*GalaxyLockEntry{
+			{
+				Name:      "community.general",
+				Version:   "8.0.0",
+				Source:    "https://galaxy.ansible.com",
+				Requested: &GalaxyRequirement{Name: "community.general"},
+			},
+		},
+	}
+}
+
+func TestGalaxyLockCheck(t *testing.T) {
+	requirements := func() *GalaxyRequirements {
+		return &GalaxyRequirements{
+			Roles: []*GalaxyRequirement{
+				{Name: "geerlingguy.docker", Version: ">=7.0.0"},
+				{Src: "https://github.com/acme/ansible-role-nginx.git", Scm: "git", Version: "v1.2.0"},
+			},
+			Collections: []*GalaxyRequirement{
+				{Name: "community.general"},
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		lock   func(*GalaxyLock)
+		req    func(*GalaxyRequirements)
+		drifts []string
+	}{
+		{
+			name: "unchanged",
+		},
+		{
+			name: "added",
+			req: func(req *GalaxyRequirements) {
+				req.Roles = append(req.Roles, &GalaxyRequirement{Name: "geerlingguy.pip"})
+				req.Collections = append(req.Collections, &GalaxyRequirement{Name: "amazon.aws"})
+			},
+			drifts: []string{
+				"role geerlingguy.pip is not locked",
+				"collection amazon.aws is not locked",
+			},
+		},
+		{
+			name: "removed",
+			req: func(req *GalaxyRequirements) {
+				req.Roles = req.Roles[1:]
+				req.Collections = nil
+			},
+			drifts: []string{
+				"role geerlingguy.docker was removed from the requirements",
+				"collection community.general was removed from the requirements",
+			},
+		},
+		{
+			name: "changed",
+			req: func(req *GalaxyRequirements) {
+				req.Roles[0].Version = ">=7.1.0"
+				req.Roles[1].Version = "v1.3.0"
+				req.Collections[0].Source = "https://hub.example.com"
+			},
+			drifts: []string{
+				"role geerlingguy.docker changed since it was locked",
+				"role nginx changed since it was locked",
+				"collection community.general changed since it was locked",
+			},
+		},
+		{
+			name: "renamed",
+			req: func(req *GalaxyRequirements) {
+				req.Roles[1].Src = "https://github.com/acme/ansible-role-web.git"
+			},
+			drifts: []string{
+				"role web is not locked",
+				"role nginx was removed from the requirements",
+			},
+		},
+		{
+			name: "requested missing from lock",
+			lock: func(lock *GalaxyLock) {
+				lock.Roles[0].Requested = nil
+				lock.Collections[0].Requested = nil
+			},
+			drifts: []string{
+				"role geerlingguy.docker changed since it was locked",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lock, req := testGalaxyLock(), requirements()
+
+			if tt.lock != nil {
+				tt.lock(lock)
+			}
+
+			if tt.req != nil {
+				tt.req(req)
+			}
+
+			err := lock.check("galaxy.lock", req)
+
+			if len(tt.drifts) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want := "galaxy requirements drifted from galaxy.lock:\n  " + strings.Join(tt.drifts, "\n  ")
+
+			if err == nil || err.Error() != want {
+				t.Errorf("got error\n%v\nwant\n%s", err, want)
+			}
+		})
+	}
+}
+
+func TestReadGalaxyLock(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(), map[string]string{
+		"valid.lock": `roles:
+  - name: geerlingguy.docker
+    version: 7.0.0
+    source: https://galaxy.ansible.com
+collections:
+  - name: community.general
+    version: 8.0.0
+    source: https://galaxy.ansible.com
+    requested:
+      name: community.general
+`,
+		"unnamed.lock": `collections:
+  - version: 8.0.0
+    source: https://galaxy.ansible.com
+`,
+	})
+
+	lock, err := readGalaxyLock(filepath.Join(dir, "valid.lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lock.Roles[0].Requested != nil || lock.Collections[0].Requested == nil {
+		t.Errorf("unexpected requested requirements %+v %+v", lock.Roles[0].Requested, lock.Collections[0].Requested)
+	}
+
+	if _, err := readGalaxyLock(filepath.Join(dir, "unnamed.lock")); err == nil || !strings.Contains(err.Error(), "entry without name") {
+		t.Errorf("got error %v, want entry without name", err)
+	}
+}
+
+func TestLockedRequirements(t *testing.T) {
+	lock := testGalaxyLock()
+	lock.Roles[0].Requested = nil
+
+	p := &Plugin{stdout: io.Discard, stderr: io.Discard}
+	t.Cleanup(p.cleanup)
+
+	req, err := p.lockedRequirements(lock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pinned []string
+	for _, r := range append(req.Roles, req.Collections...) {
+		pinned = append(pinned, r.Name+r.Src+" "+r.Version)
+	}
+
+	want := "geerlingguy.docker 7.0.0\nhttps://github.com/acme/ansible-role-nginx.git v1.2.0\ncommunity.general 8.0.0"
+	if got := strings.Join(pinned, "\n"); got != want {
+		t.Errorf("got pinned\n%s\nwant\n%s", got, want)
+	}
+
+	if _, err := os.Stat(p.Config.Galaxy); err != nil {
+		t.Errorf("pinned requirements not written: %s", err)
+	}
+
+	// the lock is left untouched
+	if lock.Roles[1].Requested.Version != "v1.2.0" || lock.Collections[0].Requested.Version != "" {
+		t.Error("pinning modified the lock")
+	}
+}
+
+func TestVerifyGalaxyLock(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(), map[string]string{
+		"roles/geerlingguy.docker/meta/.galaxy_install_info":                       "install_date: today\nversion: 7.0.0\n",
+		"roles/geerlingguy.docker/tasks/main.yml":                                  "- debug: msg=docker\n",
+		"roles/nginx/meta/.galaxy_install_info":                                    "version: v1.2.0\n",
+		"roles/nginx/tasks/main.yml":                                               "- debug: msg=nginx\n",
+		"collections/ansible_collections/community/general/MANIFEST.json":          `{"collection_info": {"version": "8.0.0"}}`,
+		"collections/ansible_collections/community/general/plugins/modules/foo.py": "# foo\n",
+	})
+
+	newPlugin := func() *Plugin {
+		p := &Plugin{stdout: io.Discard, stderr: io.Discard}
+		p.Config.GalaxyLock = "galaxy.lock"
+		p.Config.GalaxyRolesPath = filepath.Join(dir, "roles")
+		p.Config.GalaxyCollectionsPath = filepath.Join(dir, "collections")
+		return p
+	}
+
+	locked := func() *GalaxyLock {
+		lock := testGalaxyLock()
+		lock.Roles[0].Checksum = treeChecksum(filepath.Join(dir, "roles", "geerlingguy.docker"))
+		lock.Roles[1].Checksum = treeChecksum(filepath.Join(dir, "roles", "nginx"))
+		lock.Collections[0].Checksum = treeChecksum(filepath.Join(dir, "collections", "ansible_collections", "community", "general"))
+		return lock
+	}
+
+	t.Run("match", func(t *testing.T) {
+		if err := newPlugin().verifyGalaxyLock(locked()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("requested missing from lock", func(t *testing.T) {
+		lock := locked()
+		lock.Roles[0].Requested = nil
+		lock.Collections[0].Requested = nil
+
+		if err := newPlugin().verifyGalaxyLock(lock); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		lock := locked()
+		lock.Roles[0].Version = "6.0.0"
+		lock.Collections[0].Checksum = "sha256:0000"
+
+		err := newPlugin().verifyGalaxyLock(lock)
+
+		want := "installed galaxy requirements do not match galaxy.lock:\n" +
+			"  role geerlingguy.docker: locked version 6.0.0, installed 7.0.0\n" +
+			"  collection community.general: locked checksum sha256:0000, installed " +
+			treeChecksum(filepath.Join(dir, "collections", "ansible_collections", "community", "general"))
+
+		if err == nil || err.Error() != want {
+			t.Errorf("got error\n%v\nwant\n%s", err, want)
+		}
+	})
+}
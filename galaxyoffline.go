@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// galaxyArtifact is a vendored namespace-name-version.tar.gz tarball.
+type galaxyArtifact struct {
+	Name    string
+	Version string
+	Path    string
+}
+
+// galaxyArtifacts indexes the tarballs of the offline directory by name.
+func galaxyArtifacts(dir string) (map[string][]*galaxyArtifact, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read galaxy offline directory")
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve galaxy offline directory")
+	}
+
+	artifacts := map[string][]*galaxyArtifact{}
+
+	for _, entry := range entries {
+		base := strings.TrimSuffix(entry.Name(), ".tar.gz")
+		if entry.IsDir() || base == entry.Name() {
+			continue
+		}
+
+		// namespaces and names never contain dashes, versions might
+		parts := strings.SplitN(base, "-", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		name := parts[0] + "." + parts[1]
+		artifacts[name] = append(artifacts[name], &galaxyArtifact{
+			Name:    name,
+			Version: parts[2],
+			Path:    filepath.Join(abs, entry.Name()),
+		})
+	}
+
+	// newest first, so the first match is the best one
+	for _, list := range artifacts {
+		sort.Slice(list, func(i, j int) bool {
+			return compareVersions(list[i].Version, list[j].Version) > 0
+		})
+	}
+
+	return artifacts, nil
+}
+
+// offlineRequirements resolves every requirement against the vendored
+// tarballs and returns a requirements file installing them. All missing
+// artifacts are reported at once.
+func (p *Plugin) offlineRequirements(req *GalaxyRequirements) (string, error) {
+	artifacts, err := galaxyArtifacts(p.Config.GalaxyOfflineDir)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		missing  []string
+		resolved = struct {
+			Roles       []map[string]string `yaml:"roles,omitempty"`
+			Collections []map[string]string `yaml:"collections,omitempty"`
+		}{}
+	)
+
+	resolve := func(kind, name, constraint, source string) *galaxyArtifact {
+		if source != "" && source != "galaxy" {
+			missing = append(missing, fmt.Sprintf("%s %s: %s sources are not available offline", kind, name, source))
+			return nil
+		}
+
+		for _, artifact := range artifacts[name] {
+			if matchVersion(artifact.Version, constraint) {
+				return artifact
+			}
+		}
+
+		var available []string
+		for _, artifact := range artifacts[name] {
+			available = append(available, artifact.Version)
+		}
+
+		if len(available) == 0 {
+			available = append(available, "none")
+		}
+
+		missing = append(missing, fmt.Sprintf("%s %s %s (available: %s)", kind, name, orDash(constraint), strings.Join(available, ", ")))
+		return nil
+	}
+
+	for _, r := range req.Roles {
+		if artifact := resolve("role", r.RoleName(), r.Version, r.Scm); artifact != nil {
+			resolved.Roles = append(resolved.Roles, map[string]string{
+				"name": artifact.Name,
+				"src":  artifact.Path,
+			})
+		}
+	}
+
+	for _, c := range req.Collections {
+		if artifact := resolve("collection", c.Name, c.Version, c.Type); artifact != nil {
+			resolved.Collections = append(resolved.Collections, map[string]string{
+				"name": artifact.Path,
+				"type": "file",
+			})
+		}
+	}
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing galaxy artifacts in %s:\n  %s", p.Config.GalaxyOfflineDir, strings.Join(missing, "\n  "))
+	}
+
+	return p.requirementsFile(resolved)
+}
+
+// matchVersion reports whether the version satisfies a galaxy version
+// constraint like "1.2.3", ">=1.0.0,<2.0.0", "!=1.1.0" or "*". Like
+// ansible-galaxy, pre-releases only match constraints naming one.
+func matchVersion(version, constraint string) bool {
+	if strings.Contains(version, "-") && !strings.Contains(constraint, "-") {
+		return false
+	}
+
+	for _, c := range strings.Split(constraint, ",") {
+		c = strings.TrimSpace(c)
+
+		if c == "" || c == "*" {
+			continue
+		}
+
+		rest := strings.TrimLeft(c, "=!<>")
+		op := c[:len(c)-len(rest)]
+		cmp := compareVersions(version, strings.TrimSpace(rest))
+
+		var ok bool
+		switch op {
+		case "", "=", "==":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		case ">=":
+			ok = cmp >= 0
+		case "<=":
+			ok = cmp <= 0
+		case ">":
+			ok = cmp > 0
+		case "<":
+			ok = cmp < 0
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// compareVersions compares two semantic versions, pre-releases sort before
+// their release.
+func compareVersions(a, b string) int {
+	a, b = strings.TrimPrefix(a, "v"), strings.TrimPrefix(b, "v")
+
+	aRelease, aPre, _ := strings.Cut(a, "-")
+	bRelease, bPre, _ := strings.Cut(b, "-")
+
+	if cmp := compareFields(aRelease, bRelease); cmp != 0 {
+		return cmp
+	}
+
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	}
+
+	return compareFields(aPre, bPre)
+}
+
+// compareFields compares dot separated fields, numerically where possible.
+func compareFields(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y string
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+
+		xn, xErr := strconv.Atoi(x)
+		yn, yErr := strconv.Atoi(y)
+
+		switch {
+		case xErr == nil && yErr == nil && xn != yn:
+			if xn < yn {
+				return -1
+			}
+			return 1
+		case (xErr != nil || yErr != nil) && x != y:
+			// missing fields count as zero
+			if x == "" {
+				x = "0"
+			}
+			if y == "" {
+				y = "0"
+			}
+			if x != y {
+				return strings.Compare(x, y)
+			}
+		}
+	}
+
+	return 0
+}
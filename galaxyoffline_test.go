@@ -0,0 +1,155 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2.3", "1.2.4", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"2.0.0", "10.0.0", -1},
+		{"v1.2.3", "1.2.3", 0},
+		{"v2.0.0", "v1.9.9", 1},
+		{"1.0", "1.0.0", 0},
+		{"1", "1.0.0", 0},
+		{"1.0", "1.0.1", -1},
+		{"1.0.10", "1.0", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0", "1.0.0-rc.1", 1},
+		{"1.0.0-rc.1", "1.0.0-rc.2", -1},
+		{"1.0.0-rc.10", "1.0.0-rc.9", 1},
+		{"1.0.0-alpha", "1.0.0-beta", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-rc.1", "0.9.9", 1},
+	}
+
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMatchVersion(t *testing.T) {
+	tests := []struct {
+		version    string
+		constraint string
+		want       bool
+	}{
+		{"1.2.3", "", true},
+		{"1.2.3", "*", true},
+		{"1.2.3", "1.2.3", true},
+		{"1.2.3", "1.2.4", false},
+		{"1.2.3", "==1.2.3", true},
+		{"1.2.3", "=1.2.3", true},
+		{"1.2.3", "v1.2.3", true},
+		{"v1.2.3", "1.2.3", true},
+		{"1.2", "1.2.0", true},
+		{"1.1.0", "!=1.1.0", false},
+		{"1.1.1", "!=1.1.0", true},
+		{"1.5.0", ">=1.0.0,<2.0.0", true},
+		{"1.0.0", ">=1.0.0,<2.0.0", true},
+		{"2.0.0", ">=1.0.0,<2.0.0", false},
+		{"0.9.9", ">=1.0.0,<2.0.0", false},
+		{"2.0.0-rc.1", ">=1.0.0,<2.0.0", false},
+		{"2.0.0-rc.1", "", false},
+		{"2.0.0-rc.1", "*", false},
+		{"2.0.0-rc.1", "2.0.0-rc.1", true},
+		{"2.0.0-rc.2", ">=2.0.0-rc.1", true},
+		{"1.5.0", ">= 1.0.0, < 2.0.0", true},
+		{"1.1.0", ">=1.0.0,!=1.1.0", false},
+		{"1.2.0", ">1.1,<=1.2", true},
+		{"1.1.0", ">1.1", false},
+		{"1.0.0-beta", "<1.0.0", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchVersion(tt.version, tt.constraint); got != tt.want {
+			t.Errorf("matchVersion(%q, %q) = %v, want %v", tt.version, tt.constraint, got, tt.want)
+		}
+	}
+}
+
+func TestOfflineRequirements(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(), map[string]string{
+		"geerlingguy-docker-6.1.0.tar.gz":      "",
+		"geerlingguy-docker-7.0.0.tar.gz":      "",
+		"geerlingguy-docker-7.1.0-rc.1.tar.gz": "",
+		"community-general-8.0.0.tar.gz":       "",
+		"community-general-9.0.0.tar.gz":       "",
+		"README.md":                            "",
+	})
+
+	newPlugin := func() *Plugin {
+		p := &Plugin{stdout: io.Discard, stderr: io.Discard}
+		p.Config.GalaxyOfflineDir = dir
+		t.Cleanup(p.cleanup)
+		return p
+	}
+
+	t.Run("resolved", func(t *testing.T) {
+		path, err := newPlugin().offlineRequirements(&GalaxyRequirements{
+			Roles: []*GalaxyRequirement{
+				{Name: "geerlingguy.docker", Version: ">=6.0.0,<7.1.0"},
+			},
+			Collections: []*GalaxyRequirement{
+				{Name: "community.general", Version: "!=9.0.0"},
+			},
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, want := range []string{
+			filepath.Join(dir, "geerlingguy-docker-7.0.0.tar.gz"),
+			filepath.Join(dir, "community-general-8.0.0.tar.gz"),
+		} {
+			if !strings.Contains(string(content), want) {
+				t.Errorf("requirements do not install %s:\n%s", want, content)
+			}
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := newPlugin().offlineRequirements(&GalaxyRequirements{
+			Roles: []*GalaxyRequirement{
+				{Name: "geerlingguy.docker", Version: ">=8.0.0"},
+				{Name: "geerlingguy.docker"},
+				{Src: "https://github.com/acme/ansible-role-nginx.git", Scm: "git"},
+			},
+			Collections: []*GalaxyRequirement{
+				{Name: "community.general", Version: "7.0.0"},
+				{Name: "amazon.aws"},
+			},
+		})
+
+		if err == nil {
+			t.Fatal("expected an error for the missing artifacts")
+		}
+
+		want := "missing galaxy artifacts in " + dir + ":\n" +
+			"  role geerlingguy.docker >=8.0.0 (available: 7.1.0-rc.1, 7.0.0, 6.1.0)\n" +
+			"  role nginx: git sources are not available offline\n" +
+			"  collection community.general 7.0.0 (available: 9.0.0, 8.0.0)\n" +
+			"  collection amazon.aws - (available: none)"
+
+		if err.Error() != want {
+			t.Errorf("got error\n%s\nwant\n%s", err, want)
+		}
+	})
+}
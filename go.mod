@@ -5,6 +5,7 @@ go 1.23
 require (
 	github.com/pkg/errors v0.9.1
 	github.com/urfave/cli v1.22.10
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Constants for valid inventory actions
+const (
+	InventoryList  = "list"
+	InventoryGraph = "graph"
+	InventoryHost  = "host"
+)
+
+// executeInventory resolves the inventories with ansible-inventory and
+// optionally stores the result for later pipeline steps.
+func (p *Plugin) executeInventory() error {
+	switch p.Config.InventoryAction {
+	case InventoryList:
+	case InventoryGraph:
+		// the graph is plain text, later steps expect json
+		if p.Config.InventoryOutput != "" {
+			return errors.New("inventory output is not supported for graph action")
+		}
+	case InventoryHost:
+		if p.Config.InventoryHost == "" {
+			return errors.New("inventory host is required for host action")
+		}
+	default:
+		return fmt.Errorf("invalid inventory action: %s. Supported actions: list, graph, host", p.Config.InventoryAction)
+	}
+
+	if err := p.ansibleConfig(); err != nil {
+		return err
+	}
+
+	// Handle inline inventory content
+	p.setupInventory()
+
+	if err := p.prepareVirtualenv(); err != nil {
+		return err
+	}
+
+	var stdout bytes.Buffer
+
+	cmd, err := p.command(p.Config.inventoryCommand())
+	if err != nil {
+		return err
+	}
+
+	cmd.Stdout = io.MultiWriter(p.stdout, &stdout)
+	cmd.Stderr = p.stderr
+
+	trace(p.stdout, cmd)
+
+	if err := p.runCommand(cmd); err != nil {
+		return errors.Wrap(err, "ansible-inventory failed")
+	}
+
+	if p.Config.InventoryOutput == "" || p.Config.DryRun {
+		return nil
+	}
+
+	if !json.Valid(stdout.Bytes()) {
+		return errors.New("ansible-inventory returned invalid json")
+	}
+
+	if err := os.WriteFile(p.Config.InventoryOutput, stdout.Bytes(), 0644); err != nil {
+		return errors.Wrap(err, "failed to write inventory output")
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	junitTestSuites struct {
+		XMLName xml.Name          `xml:"testsuites"`
+		Suites  []*junitTestSuite `xml:"testsuite"`
+	}
+
+	junitTestSuite struct {
+		Name     string           `xml:"name,attr"`
+		Tests    int              `xml:"tests,attr"`
+		Failures int              `xml:"failures,attr"`
+		Skipped  int              `xml:"skipped,attr"`
+		Time     string           `xml:"time,attr"`
+		Cases    []*junitTestCase `xml:"testcase"`
+	}
+
+	junitTestCase struct {
+		Name      string        `xml:"name,attr"`
+		ClassName string        `xml:"classname,attr"`
+		Time      string        `xml:"time,attr"`
+		Failure   *junitFailure `xml:"failure,omitempty"`
+		Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	}
+
+	junitFailure struct {
+		Message string `xml:"message,attr"`
+		Type    string `xml:"type,attr"`
+		Content string `xml:",chardata"`
+	}
+
+	junitSkipped struct{}
+)
+
+// writeJUnit renders the results as a JUnit XML report. Every play becomes
+// a testsuite and every task/host pair a testcase. Runs without any plays,
+// like syntax checks, get a single testcase per playbook.
+func writeJUnit(path string, results []*RunResult) error {
+	report := &junitTestSuites{}
+
+	for _, result := range results {
+		if len(result.Plays) == 0 {
+			report.Suites = append(report.Suites, junitRunSuite(result))
+			continue
+		}
+
+		for _, play := range result.Plays {
+			report.Suites = append(report.Suites, junitPlaySuite(result, play))
+		}
+	}
+
+	content, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode junit report")
+	}
+
+	content = append([]byte(xml.Header), content...)
+	content = append(content, '\n')
+
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return errors.Wrap(err, "failed to write junit report")
+	}
+
+	return nil
+}
+
+func junitPlaySuite(result *RunResult, play *PlayResult) *junitTestSuite {
+	suite := &junitTestSuite{
+		Name: fmt.Sprintf("%s: %s", result.Inventory, play.Name),
+	}
+
+	var total time.Duration
+
+	for _, task := range play.Tasks {
+		testcase := &junitTestCase{
+			Name:      fmt.Sprintf("%s [%s]", task.Name, task.Host),
+			ClassName: play.Name,
+			Time:      junitSeconds(task.Duration),
+		}
+
+		switch {
+		case task.Failed():
+			suite.Failures++
+			testcase.Failure = &junitFailure{
+				Message: fmt.Sprintf("%s %s on %s", task.Name, task.Status, task.Host),
+				Type:    task.Status,
+				Content: task.Message,
+			}
+		case task.Status == TaskSkipped:
+			suite.Skipped++
+			testcase.Skipped = &junitSkipped{}
+		}
+
+		total += task.Duration
+		suite.Cases = append(suite.Cases, testcase)
+	}
+
+	suite.Tests = len(suite.Cases)
+	suite.Time = junitSeconds(total)
+
+	return suite
+}
+
+func junitRunSuite(result *RunResult) *junitTestSuite {
+	suite := &junitTestSuite{
+		Name: result.Inventory,
+		Time: junitSeconds(result.Duration),
+	}
+
+	for _, playbook := range result.Playbooks {
+		testcase := &junitTestCase{
+			Name:      playbook,
+			ClassName: result.Inventory,
+			Time:      junitSeconds(result.Duration),
+		}
+
+		if result.Error != "" {
+			suite.Failures++
+			testcase.Failure = &junitFailure{
+				Message: result.Error,
+				Type:    TaskFailed,
+			}
+		}
+
+		suite.Cases = append(suite.Cases, testcase)
+	}
+
+	suite.Tests = len(suite.Cases)
+
+	return suite
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
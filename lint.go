@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Code Climate severities, ordered from lowest to highest.
+var lintSeverities = []string{
+	"info",
+	"minor",
+	"major",
+	"critical",
+	"blocker",
+}
+
+type (
+	// LintFinding is a single ansible-lint violation in Code Climate format.
+	LintFinding struct {
+		Type        string       `json:"type"`
+		CheckName   string       `json:"check_name"`
+		Categories  []string     `json:"categories,omitempty"`
+		URL         string       `json:"url,omitempty"`
+		Severity    string       `json:"severity"`
+		Description string       `json:"description"`
+		Fingerprint string       `json:"fingerprint,omitempty"`
+		Location    LintLocation `json:"location"`
+	}
+
+	// LintLocation points to the offending file and line.
+	LintLocation struct {
+		Path      string         `json:"path"`
+		Lines     *LintLines     `json:"lines,omitempty"`
+		Positions *LintPositions `json:"positions,omitempty"`
+	}
+
+	// LintLines is the line range of a finding.
+	LintLines struct {
+		Begin int `json:"begin"`
+	}
+
+	// LintPositions is the line and column range of a finding.
+	LintPositions struct {
+		Begin struct {
+			Line   int `json:"line"`
+			Column int `json:"column,omitempty"`
+		} `json:"begin"`
+	}
+)
+
+// Line returns the line the finding starts at, or zero if unknown.
+func (l LintLocation) Line() int {
+	if l.Lines != nil {
+		return l.Lines.Begin
+	}
+	if l.Positions != nil {
+		return l.Positions.Begin.Line
+	}
+	return 0
+}
+
+// executeLint runs ansible-lint against the playbooks, writes the
+// configured reports and fails if a finding reaches the threshold.
+func (p *Plugin) executeLint() error {
+	if err := p.playbooks(); err != nil {
+		return err
+	}
+
+	threshold := lintSeverityRank(p.Config.LintFailOn)
+	if threshold < 0 {
+		return fmt.Errorf("invalid lint severity: %s. Supported severities: %s", p.Config.LintFailOn, strings.Join(lintSeverities, ", "))
+	}
+
+	if err := p.prepareVirtualenv(); err != nil {
+		return err
+	}
+
+	var stdout bytes.Buffer
+
+	cmd, err := p.command(p.Config.lintCommand())
+	if err != nil {
+		return err
+	}
+
+	cmd.Stdout = &stdout
+	cmd.Stderr = p.stderr
+
+	trace(p.stdout, cmd)
+
+	runErr := p.runCommand(cmd)
+	if err := p.cancelled(); err != nil {
+		return err
+	}
+
+	if p.Config.DryRun {
+		return nil
+	}
+
+	findings, err := parseLintFindings(stdout.Bytes())
+	if err != nil {
+		if runErr != nil {
+			return errors.Wrap(runErr, "ansible-lint failed")
+		}
+		return err
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Location.Path != findings[j].Location.Path {
+			return findings[i].Location.Path < findings[j].Location.Path
+		}
+		return findings[i].Location.Line() < findings[j].Location.Line()
+	})
+
+	var failing int
+
+	for _, f := range findings {
+		fmt.Fprintf(p.stdout, "%s:%d: [%s] %s: %s\n", f.Location.Path, f.Location.Line(), f.Severity, f.CheckName, f.Description)
+
+		if lintSeverityRank(f.Severity) >= threshold {
+			failing++
+		}
+	}
+
+	if p.Config.LintSarif != "" {
+		if err := writeSarif(p.Config.LintSarif, findings); err != nil {
+			return err
+		}
+	}
+
+	if p.Config.LintCodeClimate != "" {
+		if err := writeCodeClimate(p.Config.LintCodeClimate, findings); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(p.stdout, "Found %d lint findings, %d at or above %s severity\n", len(findings), failing, p.Config.LintFailOn)
+
+	if failing > 0 {
+		return fmt.Errorf("ansible-lint reported %d findings at or above %s severity", failing, p.Config.LintFailOn)
+	}
+
+	return nil
+}
+
+// parseLintFindings decodes the Code Climate JSON emitted by ansible-lint.
+func parseLintFindings(data []byte) ([]*LintFinding, error) {
+	data = bytes.TrimSpace(data)
+
+	if len(data) == 0 {
+		return nil, errors.New("ansible-lint produced no output")
+	}
+
+	var findings []*LintFinding
+	if err := json.Unmarshal(data, &findings); err != nil {
+		return nil, errors.Wrap(err, "failed to parse ansible-lint output")
+	}
+
+	for _, f := range findings {
+		f.Severity = strings.ToLower(f.Severity)
+
+		if lintSeverityRank(f.Severity) < 0 {
+			f.Severity = "major"
+		}
+	}
+
+	return findings, nil
+}
+
+func lintSeverityRank(severity string) int {
+	for i, s := range lintSeverities {
+		if strings.EqualFold(s, severity) {
+			return i
+		}
+	}
+	return -1
+}
+
+func writeCodeClimate(path string, findings []*LintFinding) error {
+	if findings == nil {
+		findings = []*LintFinding{}
+	}
+
+	content, err := json.MarshalIndent(findings, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode code climate report")
+	}
+
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return errors.Wrap(err, "failed to write code climate report")
+	}
+
+	return nil
+}
+
+type (
+	sarifLog struct {
+		Schema  string     `json:"$schema"`
+		Version string     `json:"version"`
+		Runs    []sarifRun `json:"runs"`
+	}
+
+	sarifRun struct {
+		Tool    sarifTool     `json:"tool"`
+		Results []sarifResult `json:"results"`
+	}
+
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+
+	sarifDriver struct {
+		Name           string      `json:"name"`
+		InformationURI string      `json:"informationUri"`
+		Rules          []sarifRule `json:"rules"`
+	}
+
+	sarifRule struct {
+		ID               string       `json:"id"`
+		ShortDescription sarifMessage `json:"shortDescription"`
+		HelpURI          string       `json:"helpUri,omitempty"`
+	}
+
+	sarifResult struct {
+		RuleID    string          `json:"ruleId"`
+		Level     string          `json:"level"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations"`
+	}
+
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+
+	sarifLocation struct {
+		PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	}
+
+	sarifPhysicalLocation struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+		Region           *sarifRegion          `json:"region,omitempty"`
+	}
+
+	sarifArtifactLocation struct {
+		URI string `json:"uri"`
+	}
+
+	sarifRegion struct {
+		StartLine int `json:"startLine"`
+	}
+)
+
+func writeSarif(path string, findings []*LintFinding) error {
+	run := sarifRun{
+		Tool: sarifTool{
+			Driver: sarifDriver{
+				Name:           "ansible-lint",
+				InformationURI: "https://github.com/ansible/ansible-lint",
+				Rules:          []sarifRule{},
+			},
+		},
+		Results: []sarifResult{},
+	}
+
+	rules := map[string]bool{}
+
+	for _, f := range findings {
+		if !rules[f.CheckName] {
+			rules[f.CheckName] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+				ID:               f.CheckName,
+				ShortDescription: sarifMessage{Text: f.CheckName},
+				HelpURI:          f.URL,
+			})
+		}
+
+		location := sarifLocation{
+			PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: f.Location.Path},
+			},
+		}
+
+		if line := f.Location.Line(); line > 0 {
+			location.PhysicalLocation.Region = &sarifRegion{StartLine: line}
+		}
+
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    f.CheckName,
+			Level:     sarifLevel(f.Severity),
+			Message:   sarifMessage{Text: f.Description},
+			Locations: []sarifLocation{location},
+		})
+	}
+
+	content, err := json.MarshalIndent(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}, "", "  ")
+
+	if err != nil {
+		return errors.Wrap(err, "failed to encode sarif report")
+	}
+
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return errors.Wrap(err, "failed to write sarif report")
+	}
+
+	return nil
+}
+
+func sarifLevel(severity string) string {
+	switch severity {
+	case "info":
+		return "note"
+	case "minor":
+		return "warning"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+// lockFile is a no-op, file locks are not supported on windows.
+func lockFile(path string, busy func()) (func(), error) {
+	return func() {}, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"log"
 	"os"
 
@@ -18,341 +19,487 @@ func main() {
 	app.Usage = "ansible plugin"
 	app.Action = run
 	app.Version = version
-	app.Flags = []cli.Flag{
-		cli.StringFlag{
-			Name:   "mode",
-			Usage:  "Mode of the functionality",
-			EnvVar: "PLUGIN_MODE",
-		},
-		cli.StringFlag{
-			Name:   "requirements",
-			Usage:  "path to python requirements",
-			EnvVar: "PLUGIN_REQUIREMENTS",
-		},
-		cli.StringFlag{
-			Name:   "galaxy",
-			Usage:  "path to galaxy requirements",
-			EnvVar: "PLUGIN_GALAXY",
-		},
-		cli.StringSliceFlag{
-			Name:   "inventory",
-			Usage:  "specify inventory host path",
-			EnvVar: "PLUGIN_INVENTORY,PLUGIN_INVENTORIES",
-		},
-		cli.StringSliceFlag{
-			Name:   "playbook",
-			Usage:  "list of playbooks to apply",
-			EnvVar: "PLUGIN_PLAYBOOK,PLUGIN_PLAYBOOKS",
-		},
-		cli.StringFlag{
-			Name:   "limit",
-			Usage:  "further limit selected hosts to an additional pattern",
-			EnvVar: "PLUGIN_LIMIT",
-		},
-		cli.StringFlag{
-			Name:   "skip-tags",
-			Usage:  "only run plays and tasks whose tags do not match",
-			EnvVar: "PLUGIN_SKIP_TAGS",
-		},
-		cli.StringFlag{
-			Name:   "start-at-task",
-			Usage:  "start the playbook at the task matching this name",
-			EnvVar: "PLUGIN_START_AT_TASK",
-		},
-		cli.StringFlag{
-			Name:   "tags",
-			Usage:  "only run plays and tasks tagged with these values",
-			EnvVar: "PLUGIN_TAGS",
-		},
-		cli.StringSliceFlag{
-			Name:   "extra-vars",
-			Usage:  "set additional variables as key=value",
-			EnvVar: "PLUGIN_EXTRA_VARS,ANSIBLE_EXTRA_VARS",
-		},
-		cli.StringSliceFlag{
-			Name:   "module-path",
-			Usage:  "prepend paths to module library",
-			EnvVar: "PLUGIN_MODULE_PATH",
-		},
-		cli.BoolTFlag{
-			Name:   "galaxy-force",
-			Usage:  "force overwriting an existing role or collection",
-			EnvVar: "PLUGIN_GALAXY_FORCE",
-		},
-		cli.BoolFlag{
-			Name:   "check",
-			Usage:  "run a check, do not apply any changes",
-			EnvVar: "PLUGIN_CHECK",
-		},
-		cli.BoolFlag{
-			Name:   "diff",
-			Usage:  "show the differences, may print secrets",
-			EnvVar: "PLUGIN_DIFF",
-		},
-		cli.BoolFlag{
-			Name:   "flush-cache",
-			Usage:  "clear the fact cache for every host in inventory",
-			EnvVar: "PLUGIN_FLUSH_CACHE",
-		},
-		cli.BoolFlag{
-			Name:   "force-handlers",
-			Usage:  "run handlers even if a task fails",
-			EnvVar: "PLUGIN_FORCE_HANDLERS",
-		},
-		cli.BoolFlag{
-			Name:   "list-hosts",
-			Usage:  "outputs a list of matching hosts",
-			EnvVar: "PLUGIN_LIST_HOSTS",
-		},
-		cli.BoolFlag{
-			Name:   "list-tags",
-			Usage:  "list all available tags",
-			EnvVar: "PLUGIN_LIST_TAGS",
-		},
-		cli.BoolFlag{
-			Name:   "list-tasks",
-			Usage:  "list all tasks that would be executed",
-			EnvVar: "PLUGIN_LIST_TASKS",
-		},
-		cli.BoolFlag{
-			Name:   "syntax-check",
-			Usage:  "perform a syntax check on the playbook",
-			EnvVar: "PLUGIN_SYNTAX_CHECK",
-		},
-		cli.IntFlag{
-			Name:   "forks",
-			Usage:  "specify number of parallel processes to use",
-			EnvVar: "PLUGIN_FORKS",
-			Value:  5,
-		},
-		cli.StringFlag{
-			Name:   "vault-id",
-			Usage:  "the vault identity to use",
-			EnvVar: "PLUGIN_VAULT_ID,ANSIBLE_VAULT_ID",
-		},
-		cli.StringFlag{
-			Name:   "vault-password",
-			Usage:  "the vault password to use",
-			EnvVar: "PLUGIN_VAULT_PASSWORD,ANSIBLE_VAULT_PASSWORD",
-		},
-		cli.IntFlag{
-			Name:   "verbose",
-			Usage:  "level of verbosity, 0 up to 4",
-			EnvVar: "PLUGIN_VERBOSE",
-		},
-		cli.StringFlag{
-			Name:   "private-key",
-			Usage:  "use this key to authenticate the connection",
-			EnvVar: "PLUGIN_PRIVATE_KEY,ANSIBLE_PRIVATE_KEY",
-		},
-		cli.StringFlag{
-			Name:   "user",
-			Usage:  "connect as this user",
-			EnvVar: "PLUGIN_USER,ANSIBLE_USER",
-		},
-		cli.StringFlag{
-			Name:   "connection",
-			Usage:  "connection type to use",
-			EnvVar: "PLUGIN_CONNECTION",
-		},
-		cli.IntFlag{
-			Name:   "timeout",
-			Usage:  "override the connection timeout in seconds",
-			EnvVar: "PLUGIN_TIMEOUT",
-		},
-		cli.StringFlag{
-			Name:   "ssh-common-args",
-			Usage:  "specify common arguments to pass to sftp/scp/ssh",
-			EnvVar: "PLUGIN_SSH_COMMON_ARGS",
-		},
-		cli.StringFlag{
-			Name:   "sftp-extra-args",
-			Usage:  "specify extra arguments to pass to sftp only",
-			EnvVar: "PLUGIN_SFTP_EXTRA_ARGS",
-		},
-		cli.StringFlag{
-			Name:   "scp-extra-args",
-			Usage:  "specify extra arguments to pass to scp only",
-			EnvVar: "PLUGIN_SCP_EXTRA_ARGS",
-		},
-		cli.StringFlag{
-			Name:   "ssh-extra-args",
-			Usage:  "specify extra arguments to pass to ssh only",
-			EnvVar: "PLUGIN_SSH_EXTRA_ARGS",
-		},
-		cli.BoolFlag{
-			Name:   "become",
-			Usage:  "run operations with become",
-			EnvVar: "PLUGIN_BECOME",
-		},
-		cli.StringFlag{
-			Name:   "become-method",
-			Usage:  "privilege escalation method to use",
-			EnvVar: "PLUGIN_BECOME_METHOD,ANSIBLE_BECOME_METHOD",
-		},
-		cli.StringFlag{
-			Name:   "become-user",
-			Usage:  "run operations as this user",
-			EnvVar: "PLUGIN_BECOME_USER,ANSIBLE_BECOME_USER",
-		},
-		cli.BoolFlag{
-			Name:   "disable-host-key-checking",
-			Usage:  "Disable validation of the host's SSH server keys",
-			EnvVar: "PLUGIN_DISABLE_HOST_KEY_CHECKING",
-		},
-		cli.BoolFlag{
-			Name:   "host-key-checking",
-			Usage:  "Enable validation of the host's SSH server keys",
-			EnvVar: "PLUGIN_HOST_KEY_CHECKING",
-		},
-		cli.StringFlag{
-			Name:   "installation",
-			Usage:  "Specify the path to Ansible installation",
-			EnvVar: "PLUGIN_INSTALLATION",
-		},
-		cli.StringFlag{
-			Name:   "inventory-content",
-			Usage:  "Inline inventory content as a string",
-			EnvVar: "PLUGIN_INVENTORY_CONTENT",
-		},
-		cli.BoolFlag{
-			Name:   "sudo",
-			Usage:  "Use sudo for operations",
-			EnvVar: "PLUGIN_SUDO",
-		},
-		cli.StringFlag{
-			Name:   "sudo-user",
-			Usage:  "Specify the sudo user (default: root)",
-			EnvVar: "PLUGIN_SUDO_USER",
-		},
-		cli.StringFlag{
-			Name:   "vault-tmp-path",
-			Usage:  "Temporary path for generated vault files",
-			EnvVar: "PLUGIN_VAULT_TMP_PATH",
-		},
-		// Ad-Hoc Specific Flags
-		cli.StringFlag{
-			Name:   "hosts",
-			Usage:  "Target hosts for ad-hoc command",
-			EnvVar: "PLUGIN_HOSTS",
-		},
-		cli.StringFlag{
-			Name:   "module",
-			Usage:  "Module name for ad-hoc execution",
-			EnvVar: "PLUGIN_MODULE",
-		},
-		cli.StringFlag{
-			Name:   "module-arguments",
-			Usage:  "Arguments for the specified module",
-			EnvVar: "PLUGIN_MODULE_ARGUMENTS",
-		},
-		cli.BoolFlag{
-			Name:   "dynamic-inventory",
-			Usage:  "Enable dynamic inventory",
-			EnvVar: "PLUGIN_DYNAMIC_INVENTORY",
-		},
-		cli.StringFlag{
-			Name:   "extras",
-			Usage:  "Additional options for ad-hoc execution",
-			EnvVar: "PLUGIN_EXTRAS",
-		},
-		cli.StringFlag{
-			Name:   "vault-credentials-key",
-			Usage:  "Vault credentials ID for encrypted files",
-			EnvVar: "PLUGIN_VAULT_CREDENTIALS_KEY",
-		},
-		// Vault Specific Flags
-		cli.StringFlag{
-			Name:   "action",
-			Usage:  "Action for ansible-vault (e.g., encrypt, decrypt, view)",
-			EnvVar: "PLUGIN_ACTION",
-		},
-		cli.StringFlag{
-			Name:   "content",
-			Usage:  "Content to encrypt or decrypt",
-			EnvVar: "PLUGIN_CONTENT",
-		},
-		cli.StringFlag{
-			Name:   "input",
-			Usage:  "Input file for the vault operation",
-			EnvVar: "PLUGIN_INPUT",
-		},
-		cli.StringFlag{
-			Name:   "output",
-			Usage:  "Output file for the vault operation",
-			EnvVar: "PLUGIN_OUTPUT",
-		},
-		cli.StringFlag{
-			Name:   "new-vault-credentials-key",
-			Usage:  "New Vault Credentials Key for rekeying",
-			EnvVar: "PLUGIN_NEW_VAULT_CREDENTIALS_KEY",
-		},
-	}
+	app.Flags = flags
 
 	if err := app.Run(os.Args); err != nil {
 		log.Fatal(err)
 	}
 }
 
+// flags are the plugin settings, read from the PLUGIN_ environment.
+var flags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "mode",
+		Usage:  "Mode of the functionality",
+		EnvVar: "PLUGIN_MODE",
+	},
+	cli.StringFlag{
+		Name:   "requirements",
+		Usage:  "path to python requirements",
+		EnvVar: "PLUGIN_REQUIREMENTS",
+	},
+	cli.StringSliceFlag{
+		Name:   "requirements-constraints",
+		Usage:  "paths to pip constraints files for the python requirements",
+		EnvVar: "PLUGIN_REQUIREMENTS_CONSTRAINTS",
+	},
+	cli.BoolFlag{
+		Name:   "virtualenv",
+		Usage:  "install the python requirements into a virtualenv and run ansible from it",
+		EnvVar: "PLUGIN_VIRTUALENV",
+	},
+	cli.StringFlag{
+		Name:   "virtualenv-python",
+		Usage:  "python interpreter creating the virtualenv",
+		Value:  "python3",
+		EnvVar: "PLUGIN_VIRTUALENV_PYTHON",
+	},
+	cli.StringFlag{
+		Name:   "virtualenv-cache-dir",
+		Usage:  "cache virtualenvs in this directory, keyed by the requirements, constraints and python version",
+		EnvVar: "PLUGIN_VIRTUALENV_CACHE_DIR",
+	},
+	cli.StringFlag{
+		Name:   "galaxy",
+		Usage:  "path to galaxy requirements",
+		EnvVar: "PLUGIN_GALAXY",
+	},
+	cli.StringSliceFlag{
+		Name:   "inventory",
+		Usage:  "specify inventory host path",
+		EnvVar: "PLUGIN_INVENTORY,PLUGIN_INVENTORIES",
+	},
+	cli.StringSliceFlag{
+		Name:   "playbook",
+		Usage:  "list of playbooks to apply",
+		EnvVar: "PLUGIN_PLAYBOOK,PLUGIN_PLAYBOOKS",
+	},
+	cli.StringFlag{
+		Name:   "limit",
+		Usage:  "further limit selected hosts to an additional pattern",
+		EnvVar: "PLUGIN_LIMIT",
+	},
+	cli.StringFlag{
+		Name:   "skip-tags",
+		Usage:  "only run plays and tasks whose tags do not match",
+		EnvVar: "PLUGIN_SKIP_TAGS",
+	},
+	cli.StringFlag{
+		Name:   "start-at-task",
+		Usage:  "start the playbook at the task matching this name",
+		EnvVar: "PLUGIN_START_AT_TASK",
+	},
+	cli.StringFlag{
+		Name:   "tags",
+		Usage:  "only run plays and tasks tagged with these values",
+		EnvVar: "PLUGIN_TAGS",
+	},
+	cli.StringFlag{
+		Name:   "extra-vars",
+		Usage:  "set additional variables as a yaml or json object, or as comma separated key=value",
+		EnvVar: "PLUGIN_EXTRA_VARS,ANSIBLE_EXTRA_VARS",
+	},
+	cli.StringFlag{
+		Name:   "config",
+		Usage:  "ansible.cfg settings as a yaml or json object of sections and keys",
+		EnvVar: "PLUGIN_CONFIG",
+	},
+	cli.StringSliceFlag{
+		Name:   "extra-vars-files",
+		Usage:  "yaml or json files with additional variables, later files take precedence",
+		EnvVar: "PLUGIN_EXTRA_VARS_FILES",
+	},
+	cli.StringSliceFlag{
+		Name:   "secret-extra-vars",
+		Usage:  "names of extra vars whose values are masked in the output",
+		EnvVar: "PLUGIN_SECRET_EXTRA_VARS",
+	},
+	cli.StringSliceFlag{
+		Name:   "module-path",
+		Usage:  "prepend paths to module library",
+		EnvVar: "PLUGIN_MODULE_PATH",
+	},
+	cli.BoolTFlag{
+		Name:   "galaxy-force",
+		Usage:  "force overwriting an existing role or collection",
+		EnvVar: "PLUGIN_GALAXY_FORCE",
+	},
+	cli.StringFlag{
+		Name:   "galaxy-roles-path",
+		Usage:  "directory to install galaxy roles to",
+		EnvVar: "PLUGIN_GALAXY_ROLES_PATH",
+	},
+	cli.StringFlag{
+		Name:   "galaxy-collections-path",
+		Usage:  "directory to install galaxy collections to",
+		EnvVar: "PLUGIN_GALAXY_COLLECTIONS_PATH",
+	},
+	cli.StringFlag{
+		Name:   "galaxy-server",
+		Usage:  "galaxy server to install roles and collections from",
+		EnvVar: "PLUGIN_GALAXY_SERVER",
+	},
+	cli.StringFlag{
+		Name:   "galaxy-cache-dir",
+		Usage:  "cache installed roles and collections in this directory, keyed by the requirements and ansible version",
+		EnvVar: "PLUGIN_GALAXY_CACHE_DIR",
+	},
+	cli.StringFlag{
+		Name:   "galaxy-offline-dir",
+		Usage:  "install roles and collections from namespace-name-version.tar.gz tarballs in this directory",
+		EnvVar: "PLUGIN_GALAXY_OFFLINE_DIR",
+	},
+	cli.StringFlag{
+		Name:   "galaxy-lock",
+		Usage:  "lockfile recording the installed roles and collections",
+		EnvVar: "PLUGIN_GALAXY_LOCK",
+	},
+	cli.StringFlag{
+		Name:   "galaxy-lock-mode",
+		Usage:  "update the lockfile after installing, or enforce it by installing exactly the locked versions",
+		Value:  "update",
+		EnvVar: "PLUGIN_GALAXY_LOCK_MODE",
+	},
+	cli.BoolFlag{
+		Name:   "galaxy-offline",
+		Usage:  "install collections without contacting a galaxy server",
+		EnvVar: "PLUGIN_GALAXY_OFFLINE",
+	},
+	cli.BoolFlag{
+		Name:   "check",
+		Usage:  "run a check, do not apply any changes",
+		EnvVar: "PLUGIN_CHECK",
+	},
+	cli.BoolFlag{
+		Name:   "diff",
+		Usage:  "show the differences, may print secrets",
+		EnvVar: "PLUGIN_DIFF",
+	},
+	cli.BoolFlag{
+		Name:   "flush-cache",
+		Usage:  "clear the fact cache for every host in inventory",
+		EnvVar: "PLUGIN_FLUSH_CACHE",
+	},
+	cli.BoolFlag{
+		Name:   "force-handlers",
+		Usage:  "run handlers even if a task fails",
+		EnvVar: "PLUGIN_FORCE_HANDLERS",
+	},
+	cli.BoolFlag{
+		Name:   "list-hosts",
+		Usage:  "outputs a list of matching hosts",
+		EnvVar: "PLUGIN_LIST_HOSTS",
+	},
+	cli.BoolFlag{
+		Name:   "list-tags",
+		Usage:  "list all available tags",
+		EnvVar: "PLUGIN_LIST_TAGS",
+	},
+	cli.BoolFlag{
+		Name:   "list-tasks",
+		Usage:  "list all tasks that would be executed",
+		EnvVar: "PLUGIN_LIST_TASKS",
+	},
+	cli.BoolFlag{
+		Name:   "syntax-check",
+		Usage:  "perform a syntax check on the playbook",
+		EnvVar: "PLUGIN_SYNTAX_CHECK",
+	},
+	cli.IntFlag{
+		Name:   "forks",
+		Usage:  "specify number of parallel processes to use",
+		EnvVar: "PLUGIN_FORKS",
+		Value:  5,
+	},
+	cli.StringFlag{
+		Name:   "vault-id",
+		Usage:  "the vault identity to use",
+		EnvVar: "PLUGIN_VAULT_ID,ANSIBLE_VAULT_ID",
+	},
+	cli.StringFlag{
+		Name:   "vault-password",
+		Usage:  "the vault password to use",
+		EnvVar: "PLUGIN_VAULT_PASSWORD,ANSIBLE_VAULT_PASSWORD",
+	},
+	cli.StringFlag{
+		Name:   "vault-ids",
+		Usage:  "vault passwords keyed by vault-id label as json object",
+		EnvVar: "PLUGIN_VAULT_IDS",
+	},
+	cli.IntFlag{
+		Name:   "verbose",
+		Usage:  "level of verbosity, 0 up to 4",
+		EnvVar: "PLUGIN_VERBOSE",
+	},
+	cli.BoolFlag{
+		Name:   "dry-run",
+		Usage:  "print the commands, environment and generated files without running anything",
+		EnvVar: "PLUGIN_DRY_RUN",
+	},
+	cli.StringFlag{
+		Name:   "private-key",
+		Usage:  "use this key to authenticate the connection",
+		EnvVar: "PLUGIN_PRIVATE_KEY,ANSIBLE_PRIVATE_KEY",
+	},
+	cli.StringFlag{
+		Name:   "user",
+		Usage:  "connect as this user",
+		EnvVar: "PLUGIN_USER,ANSIBLE_USER",
+	},
+	cli.StringFlag{
+		Name:   "connection",
+		Usage:  "connection type to use",
+		EnvVar: "PLUGIN_CONNECTION",
+	},
+	cli.IntFlag{
+		Name:   "timeout",
+		Usage:  "override the connection timeout in seconds",
+		EnvVar: "PLUGIN_TIMEOUT",
+	},
+	cli.StringFlag{
+		Name:   "ssh-common-args",
+		Usage:  "specify common arguments to pass to sftp/scp/ssh",
+		EnvVar: "PLUGIN_SSH_COMMON_ARGS",
+	},
+	cli.StringFlag{
+		Name:   "sftp-extra-args",
+		Usage:  "specify extra arguments to pass to sftp only",
+		EnvVar: "PLUGIN_SFTP_EXTRA_ARGS",
+	},
+	cli.StringFlag{
+		Name:   "scp-extra-args",
+		Usage:  "specify extra arguments to pass to scp only",
+		EnvVar: "PLUGIN_SCP_EXTRA_ARGS",
+	},
+	cli.StringFlag{
+		Name:   "ssh-extra-args",
+		Usage:  "specify extra arguments to pass to ssh only",
+		EnvVar: "PLUGIN_SSH_EXTRA_ARGS",
+	},
+	cli.BoolFlag{
+		Name:   "become",
+		Usage:  "run operations with become",
+		EnvVar: "PLUGIN_BECOME",
+	},
+	cli.StringFlag{
+		Name:   "become-method",
+		Usage:  "privilege escalation method to use",
+		EnvVar: "PLUGIN_BECOME_METHOD,ANSIBLE_BECOME_METHOD",
+	},
+	cli.StringFlag{
+		Name:   "become-user",
+		Usage:  "run operations as this user",
+		EnvVar: "PLUGIN_BECOME_USER,ANSIBLE_BECOME_USER",
+	},
+	cli.BoolFlag{
+		Name:   "disable-host-key-checking",
+		Usage:  "Disable validation of the host's SSH server keys",
+		EnvVar: "PLUGIN_DISABLE_HOST_KEY_CHECKING",
+	},
+	cli.BoolFlag{
+		Name:   "host-key-checking",
+		Usage:  "Enable validation of the host's SSH server keys",
+		EnvVar: "PLUGIN_HOST_KEY_CHECKING",
+	},
+	cli.StringFlag{
+		Name:   "installation",
+		Usage:  "Specify the path to Ansible installation",
+		EnvVar: "PLUGIN_INSTALLATION",
+	},
+	cli.StringFlag{
+		Name:   "inventory-content",
+		Usage:  "Inline inventory content as a string",
+		EnvVar: "PLUGIN_INVENTORY_CONTENT",
+	},
+	cli.BoolFlag{
+		Name:   "sudo",
+		Usage:  "Use sudo for operations",
+		EnvVar: "PLUGIN_SUDO",
+	},
+	cli.StringFlag{
+		Name:   "sudo-user",
+		Usage:  "Specify the sudo user (default: root)",
+		EnvVar: "PLUGIN_SUDO_USER",
+	},
+	cli.StringFlag{
+		Name:   "vault-tmp-path",
+		Usage:  "Temporary path for generated vault files",
+		EnvVar: "PLUGIN_VAULT_TMP_PATH",
+	},
+	// Ad-Hoc Specific Flags
+	cli.StringFlag{
+		Name:   "hosts",
+		Usage:  "Target hosts for ad-hoc command",
+		EnvVar: "PLUGIN_HOSTS",
+	},
+	cli.StringFlag{
+		Name:   "module",
+		Usage:  "Module name for ad-hoc execution",
+		EnvVar: "PLUGIN_MODULE",
+	},
+	cli.StringFlag{
+		Name:   "module-arguments",
+		Usage:  "Arguments for the specified module",
+		EnvVar: "PLUGIN_MODULE_ARGUMENTS",
+	},
+	cli.BoolFlag{
+		Name:   "dynamic-inventory",
+		Usage:  "Enable dynamic inventory",
+		EnvVar: "PLUGIN_DYNAMIC_INVENTORY",
+	},
+	cli.StringFlag{
+		Name:   "extras",
+		Usage:  "Additional options for ad-hoc execution",
+		EnvVar: "PLUGIN_EXTRAS",
+	},
+	cli.StringFlag{
+		Name:   "vault-credentials-key",
+		Usage:  "Vault credentials ID for encrypted files",
+		EnvVar: "PLUGIN_VAULT_CREDENTIALS_KEY",
+	},
+	// Vault Specific Flags
+	cli.StringFlag{
+		Name:   "action",
+		Usage:  "Action for ansible-vault (e.g., encrypt, decrypt, view, verify)",
+		EnvVar: "PLUGIN_ACTION",
+	},
+	cli.StringFlag{
+		Name:   "content",
+		Usage:  "Content to encrypt or decrypt",
+		EnvVar: "PLUGIN_CONTENT",
+	},
+	cli.StringFlag{
+		Name:   "input",
+		Usage:  "Input files or globs for the vault operation",
+		EnvVar: "PLUGIN_INPUT",
+	},
+	cli.StringFlag{
+		Name:   "output",
+		Usage:  "Output file for the vault operation",
+		EnvVar: "PLUGIN_OUTPUT",
+	},
+	cli.StringFlag{
+		Name:   "new-vault-credentials-key",
+		Usage:  "New Vault Credentials Key for rekeying",
+		EnvVar: "PLUGIN_NEW_VAULT_CREDENTIALS_KEY",
+	},
+	cli.StringFlag{
+		Name:   "new-vault-id",
+		Usage:  "vault-id label to move files to when rekeying",
+		EnvVar: "PLUGIN_NEW_VAULT_ID",
+	},
+	cli.IntFlag{
+		Name:   "retries",
+		Usage:  "number of times to rerun the playbook against failed hosts",
+		EnvVar: "PLUGIN_RETRIES",
+	},
+	cli.IntFlag{
+		Name:   "retry-delay",
+		Usage:  "seconds to wait before rerunning failed hosts",
+		EnvVar: "PLUGIN_RETRY_DELAY",
+	},
+	cli.IntFlag{
+		Name:   "grace-period",
+		Usage:  "seconds to wait for ansible to exit after forwarding a signal",
+		EnvVar: "PLUGIN_GRACE_PERIOD",
+		Value:  10,
+	},
+	cli.BoolFlag{
+		Name:   "parallel-inventories",
+		Usage:  "run the playbook against all inventories concurrently",
+		EnvVar: "PLUGIN_PARALLEL_INVENTORIES",
+	},
+	cli.IntFlag{
+		Name:   "parallel-limit",
+		Usage:  "maximum number of inventories to run concurrently, 0 for no limit",
+		EnvVar: "PLUGIN_PARALLEL_LIMIT",
+	},
+	cli.StringFlag{
+		Name:   "results-file",
+		Usage:  "write the per-host playbook results as json to this file",
+		EnvVar: "PLUGIN_RESULTS_FILE",
+	},
+	cli.StringFlag{
+		Name:   "report-junit",
+		Usage:  "write a junit xml report of the playbook run to this file",
+		EnvVar: "PLUGIN_REPORT_JUNIT",
+	},
+	cli.StringFlag{
+		Name:   "card-path",
+		Usage:  "write a drone card with the deployment summary to this file",
+		EnvVar: "PLUGIN_CARD_PATH,DRONE_CARD_PATH",
+	},
+	cli.StringFlag{
+		Name:   "outputs-file",
+		Usage:  "append the run results as dotenv step outputs to this file",
+		EnvVar: "PLUGIN_OUTPUTS_FILE,DRONE_OUTPUT",
+	},
+	// Lint Specific Flags
+	cli.StringFlag{
+		Name:   "lint-sarif",
+		Usage:  "write the lint findings as sarif to this file",
+		EnvVar: "PLUGIN_LINT_SARIF",
+	},
+	cli.StringFlag{
+		Name:   "lint-codeclimate",
+		Usage:  "write the lint findings as code climate json to this file",
+		EnvVar: "PLUGIN_LINT_CODECLIMATE",
+	},
+	cli.StringFlag{
+		Name:   "lint-fail-on",
+		Usage:  "minimum severity which fails the lint (info, minor, major, critical, blocker)",
+		EnvVar: "PLUGIN_LINT_FAIL_ON",
+		Value:  "major",
+	},
+	// Inventory Specific Flags
+	cli.StringFlag{
+		Name:   "inventory-action",
+		Usage:  "action for ansible-inventory (list, graph, host)",
+		EnvVar: "PLUGIN_INVENTORY_ACTION",
+		Value:  "list",
+	},
+	cli.StringFlag{
+		Name:   "inventory-host",
+		Usage:  "host to show the variables of with the host action",
+		EnvVar: "PLUGIN_INVENTORY_HOST",
+	},
+	cli.StringFlag{
+		Name:   "inventory-output",
+		Usage:  "write the resolved inventory as json to this file, for the list and host actions",
+		EnvVar: "PLUGIN_INVENTORY_OUTPUT",
+	},
+	// Drift Specific Flags
+	cli.StringFlag{
+		Name:   "drift-report",
+		Usage:  "write the drift report as json to this file",
+		EnvVar: "PLUGIN_DRIFT_REPORT",
+	},
+	cli.IntFlag{
+		Name:   "drift-threshold",
+		Usage:  "number of drifted tasks tolerated before failing, negative to only fail on drift tags",
+		EnvVar: "PLUGIN_DRIFT_THRESHOLD",
+	},
+	cli.StringSliceFlag{
+		Name:   "drift-tags",
+		Usage:  "fail if a task with one of these tags drifted",
+		EnvVar: "PLUGIN_DRIFT_TAGS",
+	},
+}
+
 func run(c *cli.Context) error {
+	config, err := configFromContext(c)
+	if err != nil {
+		return err
+	}
+
 	plugin := Plugin{
-		Config: Config{
-			Mode:                   c.String("mode"),
-			Requirements:           c.String("requirements"),
-			Galaxy:                 c.String("galaxy"),
-			Inventories:            c.StringSlice("inventory"),
-			Playbooks:              c.StringSlice("playbook"),
-			Limit:                  c.String("limit"),
-			SkipTags:               c.String("skip-tags"),
-			StartAtTask:            c.String("start-at-task"),
-			Tags:                   c.String("tags"),
-			ExtraVars:              c.StringSlice("extra-vars"),
-			ModulePath:             c.StringSlice("module-path"),
-			GalaxyForce:            c.Bool("galaxy-force"),
-			Check:                  c.Bool("check"),
-			Diff:                   c.Bool("diff"),
-			FlushCache:             c.Bool("flush-cache"),
-			ForceHandlers:          c.Bool("force-handlers"),
-			ListHosts:              c.Bool("list-hosts"),
-			ListTags:               c.Bool("list-tags"),
-			ListTasks:              c.Bool("list-tasks"),
-			SyntaxCheck:            c.Bool("syntax-check"),
-			Forks:                  c.Int("forks"),
-			VaultID:                c.String("vailt-id"),
-			VaultPassword:          c.String("vault-password"),
-			Verbose:                c.Int("verbose"),
-			PrivateKey:             c.String("private-key"),
-			User:                   c.String("user"),
-			Connection:             c.String("connection"),
-			Timeout:                c.Int("timeout"),
-			SSHCommonArgs:          c.String("ssh-common-args"),
-			SFTPExtraArgs:          c.String("sftp-extra-args"),
-			SCPExtraArgs:           c.String("scp-extra-args"),
-			SSHExtraArgs:           c.String("ssh-extra-args"),
-			Become:                 c.Bool("become"),
-			BecomeMethod:           c.String("become-method"),
-			BecomeUser:             c.String("become-user"),
-			DisableHostKeyChecking: c.Bool("disable-host-key-checking"), // Disable SSH host key checking
-			HostKeyChecking:        c.Bool("host-key-checking"),         // Enable SSH host key validation
-			Installation:           c.String("installation"),            // Path to the Ansible executable or installation
-			InventoryContent:       c.String("inventory-content"),       // Inline inventory content
-			Sudo:                   c.Bool("sudo"),                      // Use sudo for operations
-			SudoUser:               c.String("sudo-user"),               // Sudo user for operations
-			VaultTmpPath:           c.String("vault-tmp-path"),          // Temporary path for vault password files and others
-			// Ad-Hoc Parameters
-			Hosts:               c.String("hosts"),                 // Target hosts for ad-hoc command
-			Module:              c.String("module"),                // Module name for ad-hoc command
-			ModuleArguments:     c.String("module-arguments"),      // Module arguments for ad-hoc command
-			DynamicInventory:    c.Bool("dynamic-inventory"),       // Enable dynamic inventory
-			Extras:              c.String("extras"),                // Additional options for ad-hoc execution
-			VaultCredentialsKey: c.String("vault-credentials-key"), // Vault credentials ID for encrypted files
-			// Vault Parameters
-			Action:                 c.String("action"),
-			Content:                c.String("content"),
-			Input:                  c.String("input"),
-			Output:                 c.String("output"),
-			NewVaultCredentialsKey: c.String("new-vault-credentials-key"),
-		},
+		Config: config,
+	}
+
+	switch plugin.Config.GalaxyLockMode {
+	case GalaxyLockUpdate, GalaxyLockEnforce:
+	default:
+		return errors.Errorf("invalid galaxy lock mode %q: specify 'update' or 'enforce'", plugin.Config.GalaxyLockMode)
 	}
 
 	// Set default mode to "playbook" if not explicitly provided
@@ -362,12 +509,12 @@ func run(c *cli.Context) error {
 
 	// Validate mode and required parameters based on the mode
 	switch plugin.Config.Mode {
-	case "playbook":
+	case "playbook", "drift":
 		if len(plugin.Config.Playbooks) == 0 {
-			return errors.New("you must provide a playbook in playbook mode")
+			return errors.Errorf("you must provide a playbook in %s mode", plugin.Config.Mode)
 		}
 		if len(plugin.Config.Inventories) == 0 && plugin.Config.InventoryContent == "" {
-			return errors.New("you must provide an inventory or inventory content in playbook mode")
+			return errors.Errorf("you must provide an inventory or inventory content in %s mode", plugin.Config.Mode)
 		}
 	case "adhoc":
 		if plugin.Config.Hosts == "" {
@@ -375,13 +522,138 @@ func run(c *cli.Context) error {
 		}
 		// Module is optional; defaults to "command" if not provided
 	case "vault":
-		if plugin.Config.VaultCredentialsKey == "" {
-			return errors.New("VaultCredentialsKey is mandatory for vault mode")
+		if plugin.Config.VaultCredentialsKey == "" && len(plugin.Config.VaultIDs) == 0 {
+			return errors.New("VaultCredentialsKey or vault_ids is mandatory for vault mode")
 		}
 		// Action, Content, Input, and Output are optional
+	case "lint":
+		if len(plugin.Config.Playbooks) == 0 {
+			return errors.New("you must provide a playbook in lint mode")
+		}
+	case "inventory":
+		if len(plugin.Config.Inventories) == 0 && plugin.Config.InventoryContent == "" {
+			return errors.New("you must provide an inventory or inventory content in inventory mode")
+		}
 	default:
-		return errors.New("invalid mode: specify 'playbook', 'adhoc', or 'vault'")
+		return errors.New("invalid mode: specify 'playbook', 'adhoc', 'vault', 'lint', 'inventory', or 'drift'")
 	}
 
 	return plugin.Exec()
 }
+
+// configFromContext maps the settings to the plugin config.
+func configFromContext(c *cli.Context) (Config, error) {
+	config := Config{
+		Mode:                   c.String("mode"),
+		Requirements:           c.String("requirements"),
+		Constraints:            c.StringSlice("requirements-constraints"),
+		Virtualenv:             c.Bool("virtualenv"),
+		VirtualenvPython:       c.String("virtualenv-python"),
+		VirtualenvCacheDir:     c.String("virtualenv-cache-dir"),
+		Galaxy:                 c.String("galaxy"),
+		Inventories:            c.StringSlice("inventory"),
+		Playbooks:              c.StringSlice("playbook"),
+		Limit:                  c.String("limit"),
+		SkipTags:               c.String("skip-tags"),
+		StartAtTask:            c.String("start-at-task"),
+		Tags:                   c.String("tags"),
+		ExtraVarsFiles:         c.StringSlice("extra-vars-files"),
+		ModulePath:             c.StringSlice("module-path"),
+		GalaxyForce:            c.Bool("galaxy-force"),
+		GalaxyRolesPath:        c.String("galaxy-roles-path"),
+		GalaxyCollectionsPath:  c.String("galaxy-collections-path"),
+		GalaxyServer:           c.String("galaxy-server"),
+		GalaxyOffline:          c.Bool("galaxy-offline"),
+		GalaxyCacheDir:         c.String("galaxy-cache-dir"),
+		GalaxyOfflineDir:       c.String("galaxy-offline-dir"),
+		GalaxyLock:             c.String("galaxy-lock"),
+		GalaxyLockMode:         c.String("galaxy-lock-mode"),
+		Check:                  c.Bool("check"),
+		Diff:                   c.Bool("diff"),
+		FlushCache:             c.Bool("flush-cache"),
+		ForceHandlers:          c.Bool("force-handlers"),
+		ListHosts:              c.Bool("list-hosts"),
+		ListTags:               c.Bool("list-tags"),
+		ListTasks:              c.Bool("list-tasks"),
+		SyntaxCheck:            c.Bool("syntax-check"),
+		Forks:                  c.Int("forks"),
+		VaultID:                c.String("vault-id"),
+		VaultPassword:          c.String("vault-password"),
+		Verbose:                c.Int("verbose"),
+		DryRun:                 c.Bool("dry-run"),
+		PrivateKey:             c.String("private-key"),
+		User:                   c.String("user"),
+		Connection:             c.String("connection"),
+		Timeout:                c.Int("timeout"),
+		SSHCommonArgs:          c.String("ssh-common-args"),
+		SFTPExtraArgs:          c.String("sftp-extra-args"),
+		SCPExtraArgs:           c.String("scp-extra-args"),
+		SSHExtraArgs:           c.String("ssh-extra-args"),
+		Become:                 c.Bool("become"),
+		BecomeMethod:           c.String("become-method"),
+		BecomeUser:             c.String("become-user"),
+		DisableHostKeyChecking: c.Bool("disable-host-key-checking"), // Disable SSH host key checking
+		HostKeyChecking:        c.Bool("host-key-checking"),         // Enable SSH host key validation
+		Installation:           c.String("installation"),            // Path to the Ansible executable or installation
+		InventoryContent:       c.String("inventory-content"),       // Inline inventory content
+		Sudo:                   c.Bool("sudo"),                      // Use sudo for operations
+		SudoUser:               c.String("sudo-user"),               // Sudo user for operations
+		VaultTmpPath:           c.String("vault-tmp-path"),          // Temporary path for vault password files and others
+		// Ad-Hoc Parameters
+		Hosts:               c.String("hosts"),                 // Target hosts for ad-hoc command
+		Module:              c.String("module"),                // Module name for ad-hoc command
+		ModuleArguments:     c.String("module-arguments"),      // Module arguments for ad-hoc command
+		DynamicInventory:    c.Bool("dynamic-inventory"),       // Enable dynamic inventory
+		Extras:              c.String("extras"),                // Additional options for ad-hoc execution
+		VaultCredentialsKey: c.String("vault-credentials-key"), // Vault credentials ID for encrypted files
+		// Vault Parameters
+		Action:                 c.String("action"),
+		Content:                c.String("content"),
+		Input:                  c.String("input"),
+		Output:                 c.String("output"),
+		NewVaultCredentialsKey: c.String("new-vault-credentials-key"),
+		NewVaultID:             c.String("new-vault-id"),
+		Retries:                c.Int("retries"),
+		RetryDelay:             c.Int("retry-delay"),
+		GracePeriod:            c.Int("grace-period"),
+		ParallelInventories:    c.Bool("parallel-inventories"),
+		ParallelLimit:          c.Int("parallel-limit"),
+		SecretExtraVars:        c.StringSlice("secret-extra-vars"),
+		ResultsFile:            c.String("results-file"),
+		ReportJUnit:            c.String("report-junit"),
+		CardPath:               c.String("card-path"),
+		OutputsFile:            c.String("outputs-file"),
+		// Lint Parameters
+		LintSarif:       c.String("lint-sarif"),
+		LintCodeClimate: c.String("lint-codeclimate"),
+		LintFailOn:      c.String("lint-fail-on"),
+		// Inventory Parameters
+		InventoryAction: c.String("inventory-action"),
+		InventoryHost:   c.String("inventory-host"),
+		InventoryOutput: c.String("inventory-output"),
+		// Drift Parameters
+		DriftReport:    c.String("drift-report"),
+		DriftThreshold: c.Int("drift-threshold"),
+		DriftTags:      c.StringSlice("drift-tags"),
+	}
+
+	extraVarsMap, extraVars, err := parseExtraVars(c.String("extra-vars"))
+	if err != nil {
+		return Config{}, err
+	}
+	config.ExtraVarsMap, config.ExtraVars = extraVarsMap, extraVars
+
+	ansibleConfig, err := parseAnsibleConfig(c.String("config"))
+	if err != nil {
+		return Config{}, err
+	}
+	config.AnsibleConfig = ansibleConfig
+
+	if raw := c.String("vault-ids"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &config.VaultIDs); err != nil {
+			return Config{}, errors.Wrap(err, "failed to parse vault ids")
+		}
+	}
+
+	return config, nil
+}
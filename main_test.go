@@ -0,0 +1,257 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+// configFields maps the settings to the config fields they set. Settings
+// parsed into structured fields are tested separately.
+var configFields = map[string]string{
+	"mode":                      "Mode",
+	"requirements":              "Requirements",
+	"requirements-constraints":  "Constraints",
+	"virtualenv":                "Virtualenv",
+	"virtualenv-python":         "VirtualenvPython",
+	"virtualenv-cache-dir":      "VirtualenvCacheDir",
+	"galaxy":                    "Galaxy",
+	"inventory":                 "Inventories",
+	"playbook":                  "Playbooks",
+	"limit":                     "Limit",
+	"skip-tags":                 "SkipTags",
+	"start-at-task":             "StartAtTask",
+	"tags":                      "Tags",
+	"extra-vars-files":          "ExtraVarsFiles",
+	"module-path":               "ModulePath",
+	"galaxy-force":              "GalaxyForce",
+	"galaxy-roles-path":         "GalaxyRolesPath",
+	"galaxy-collections-path":   "GalaxyCollectionsPath",
+	"galaxy-server":             "GalaxyServer",
+	"galaxy-offline":            "GalaxyOffline",
+	"galaxy-cache-dir":          "GalaxyCacheDir",
+	"galaxy-offline-dir":        "GalaxyOfflineDir",
+	"galaxy-lock":               "GalaxyLock",
+	"galaxy-lock-mode":          "GalaxyLockMode",
+	"check":                     "Check",
+	"diff":                      "Diff",
+	"flush-cache":               "FlushCache",
+	"force-handlers":            "ForceHandlers",
+	"list-hosts":                "ListHosts",
+	"list-tags":                 "ListTags",
+	"list-tasks":                "ListTasks",
+	"syntax-check":              "SyntaxCheck",
+	"forks":                     "Forks",
+	"vault-id":                  "VaultID",
+	"vault-password":            "VaultPassword",
+	"verbose":                   "Verbose",
+	"dry-run":                   "DryRun",
+	"private-key":               "PrivateKey",
+	"user":                      "User",
+	"connection":                "Connection",
+	"timeout":                   "Timeout",
+	"ssh-common-args":           "SSHCommonArgs",
+	"sftp-extra-args":           "SFTPExtraArgs",
+	"scp-extra-args":            "SCPExtraArgs",
+	"ssh-extra-args":            "SSHExtraArgs",
+	"become":                    "Become",
+	"become-method":             "BecomeMethod",
+	"become-user":               "BecomeUser",
+	"disable-host-key-checking": "DisableHostKeyChecking",
+	"host-key-checking":         "HostKeyChecking",
+	"installation":              "Installation",
+	"inventory-content":         "InventoryContent",
+	"sudo":                      "Sudo",
+	"sudo-user":                 "SudoUser",
+	"vault-tmp-path":            "VaultTmpPath",
+	"hosts":                     "Hosts",
+	"module":                    "Module",
+	"module-arguments":          "ModuleArguments",
+	"dynamic-inventory":         "DynamicInventory",
+	"extras":                    "Extras",
+	"vault-credentials-key":     "VaultCredentialsKey",
+	"action":                    "Action",
+	"content":                   "Content",
+	"input":                     "Input",
+	"output":                    "Output",
+	"new-vault-credentials-key": "NewVaultCredentialsKey",
+	"new-vault-id":              "NewVaultID",
+	"retries":                   "Retries",
+	"retry-delay":               "RetryDelay",
+	"grace-period":              "GracePeriod",
+	"parallel-inventories":      "ParallelInventories",
+	"parallel-limit":            "ParallelLimit",
+	"secret-extra-vars":         "SecretExtraVars",
+	"results-file":              "ResultsFile",
+	"report-junit":              "ReportJUnit",
+	"card-path":                 "CardPath",
+	"outputs-file":              "OutputsFile",
+	"lint-sarif":                "LintSarif",
+	"lint-codeclimate":          "LintCodeClimate",
+	"lint-fail-on":              "LintFailOn",
+	"inventory-action":          "InventoryAction",
+	"inventory-host":            "InventoryHost",
+	"inventory-output":          "InventoryOutput",
+	"drift-report":              "DriftReport",
+	"drift-threshold":           "DriftThreshold",
+	"drift-tags":                "DriftTags",
+}
+
+// parsedSettings are settings decoded into structured config fields.
+var parsedSettings = map[string]string{
+	"extra-vars": `{"app": {"version": 2}}`,
+	"config":     `{"defaults": {"forks": 10}}`,
+	"vault-ids":  `{"prod": "prod-secret"}`,
+}
+
+// configFromEnv runs the cli app with the environment and returns the
+// resulting config.
+func configFromEnv(t *testing.T, env map[string]string) (Config, error) {
+	t.Helper()
+
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+
+	var (
+		config Config
+		err    error
+	)
+
+	app := cli.NewApp()
+	app.Flags = flags
+	app.Action = func(c *cli.Context) error {
+		config, err = configFromContext(c)
+		return nil
+	}
+
+	if runErr := app.Run([]string{"plugin"}); runErr != nil {
+		t.Fatal(runErr)
+	}
+
+	return config, err
+}
+
+func TestConfigFromContext(t *testing.T) {
+	var (
+		env  = map[string]string{}
+		want = map[string]interface{}{}
+	)
+
+	for i, flag := range flags {
+		var (
+			name   string
+			envVar string
+			value  string
+			field  interface{}
+		)
+
+		switch f := flag.(type) {
+		case cli.StringFlag:
+			name, envVar = f.Name, f.EnvVar
+			value = f.Name + "-value"
+			field = value
+		case cli.StringSliceFlag:
+			name, envVar = f.Name, f.EnvVar
+			value = f.Name + "-a," + f.Name + "-b"
+			field = []string{f.Name + "-a", f.Name + "-b"}
+		case cli.BoolFlag:
+			name, envVar = f.Name, f.EnvVar
+			value, field = "true", true
+		case cli.BoolTFlag:
+			name, envVar = f.Name, f.EnvVar
+			value, field = "false", false
+		case cli.IntFlag:
+			name, envVar = f.Name, f.EnvVar
+			value = strconv.Itoa(100 + i)
+			field = 100 + i
+		default:
+			t.Fatalf("unexpected flag type %T", flag)
+		}
+
+		// aliases follow the plugin setting
+		envVar = strings.Split(envVar, ",")[0]
+
+		if raw, ok := parsedSettings[name]; ok {
+			env[envVar] = raw
+			continue
+		}
+
+		fieldName, ok := configFields[name]
+		if !ok {
+			t.Errorf("setting %s is not mapped to a config field", name)
+			continue
+		}
+
+		env[envVar] = value
+		want[fieldName] = field
+	}
+
+	if len(want)+len(parsedSettings) != len(flags) {
+		t.Errorf("got %d mapped settings for %d flags", len(want)+len(parsedSettings), len(flags))
+	}
+
+	config, err := configFromEnv(t, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := reflect.ValueOf(config)
+
+	for fieldName, expected := range want {
+		field := value.FieldByName(fieldName)
+		if !field.IsValid() {
+			t.Errorf("config has no field %s", fieldName)
+			continue
+		}
+
+		if got := field.Interface(); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: got %#v, want %#v", fieldName, got, expected)
+		}
+	}
+
+	if got := config.ExtraVarsMap; !reflect.DeepEqual(got, map[string]interface{}{"app": map[string]interface{}{"version": 2}}) {
+		t.Errorf("ExtraVarsMap: got %#v", got)
+	}
+
+	if got := config.AnsibleConfig; !reflect.DeepEqual(got, map[string]map[string]string{"defaults": {"forks": "10"}}) {
+		t.Errorf("AnsibleConfig: got %#v", got)
+	}
+
+	if got := config.VaultIDs; !reflect.DeepEqual(got, map[string]string{"prod": "prod-secret"}) {
+		t.Errorf("VaultIDs: got %#v", got)
+	}
+}
+
+func TestConfigFromContextDefaults(t *testing.T) {
+	config, err := configFromEnv(t, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.VirtualenvPython != "python3" || config.GalaxyLockMode != GalaxyLockUpdate || !config.GalaxyForce || config.Forks != 5 {
+		t.Errorf("unexpected defaults %+v", config)
+	}
+}
+
+func TestConfigFromContextErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{"extra vars", map[string]string{"PLUGIN_EXTRA_VARS": `{"a": 1`}},
+		{"config", map[string]string{"PLUGIN_CONFIG": `{"defaults": {"a": {"b": 1}}}`}},
+		{"vault ids", map[string]string{"PLUGIN_VAULT_IDS": `["prod"]`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := configFromEnv(t, tt.env); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// secretMask replaces masked secrets in the output.
+const secretMask = "********"
+
+// syncWriter serializes writes from concurrent commands.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.w.Write(p)
+}
+
+// prefixWriter prepends every complete line with a prefix before passing
+// it on, so the output of concurrent commands stays attributable.
+type prefixWriter struct {
+	w      io.Writer
+	prefix []byte
+	buf    []byte
+}
+
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{
+		w:      w,
+		prefix: []byte("[" + prefix + "] "),
+	}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		if err := p.writeLine(p.buf[:i+1]); err != nil {
+			return len(b), err
+		}
+
+		p.buf = p.buf[i+1:]
+	}
+
+	return len(b), nil
+}
+
+// Flush writes any trailing output not terminated by a newline.
+func (p *prefixWriter) Flush() error {
+	if len(p.buf) == 0 {
+		return nil
+	}
+
+	line := append(p.buf, '\n')
+	p.buf = nil
+
+	return p.writeLine(line)
+}
+
+func (p *prefixWriter) writeLine(line []byte) error {
+	out := make([]byte, 0, len(p.prefix)+len(line))
+	out = append(out, p.prefix...)
+	out = append(out, line...)
+
+	_, err := p.w.Write(out)
+	return err
+}
+
+// maskWriter redacts secrets from everything written through it. Output is
+// buffered up to the next newline, so secrets split across writes are
+// still masked. Writes are serialized, as the signal handling writes to
+// the same writer as the running command.
+type maskWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	replacer *strings.Replacer
+	buf      []byte
+}
+
+func newMaskWriter(w io.Writer, secrets []string) *maskWriter {
+	var (
+		patterns []string
+		seen     = map[string]bool{}
+	)
+
+	add := func(value string) {
+		if len(value) < 3 || seen[value] {
+			return
+		}
+
+		seen[value] = true
+		patterns = append(patterns, value)
+	}
+
+	for _, secret := range secrets {
+		add(secret)
+		add(strings.TrimSpace(secret))
+		add(base64.StdEncoding.EncodeToString([]byte(secret)))
+		add(base64.RawStdEncoding.EncodeToString([]byte(secret)))
+
+		// multi-line secrets like private keys are printed line by line
+		for _, line := range strings.Split(secret, "\n") {
+			add(strings.TrimSpace(line))
+		}
+	}
+
+	// prefer the longest match, the replacer picks the first pattern
+	// matching at a position
+	sort.SliceStable(patterns, func(i, j int) bool {
+		return len(patterns[i]) > len(patterns[j])
+	})
+
+	pairs := make([]string, 0, 2*len(patterns))
+	for _, pattern := range patterns {
+		pairs = append(pairs, pattern, secretMask)
+	}
+
+	return &maskWriter{
+		w:        w,
+		replacer: strings.NewReplacer(pairs...),
+	}
+}
+
+func (m *maskWriter) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.buf = append(m.buf, p...)
+
+	i := bytes.LastIndexByte(m.buf, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+
+	if _, err := io.WriteString(m.w, m.replacer.Replace(string(m.buf[:i+1]))); err != nil {
+		return len(p), err
+	}
+
+	m.buf = append(m.buf[:0], m.buf[i+1:]...)
+	return len(p), nil
+}
+
+// Flush writes any trailing output not terminated by a newline.
+func (m *maskWriter) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.buf) == 0 {
+		return nil
+	}
+
+	_, err := io.WriteString(m.w, m.replacer.Replace(string(m.buf)))
+	m.buf = nil
+
+	return err
+}
+
+// secrets returns every plugin supplied secret which must not show up in
+// the output.
+func (p *Plugin) secrets() []string {
+	secrets := []string{
+		p.Config.PrivateKey,
+		p.Config.VaultPassword,
+		p.Config.VaultCredentialsKey,
+		p.Config.NewVaultCredentialsKey,
+	}
+
+	for _, password := range p.Config.VaultIDs {
+		secrets = append(secrets, password)
+	}
+
+	secrets = append(secrets, secretExtraVars(p.Config.ExtraVars, p.Config.SecretExtraVars)...)
+
+	for _, name := range p.Config.SecretExtraVars {
+		secrets = appendStrings(secrets, p.Config.ExtraVarsMap[name])
+	}
+
+	return secrets
+}
+
+// appendStrings appends all string values nested in value.
+func appendStrings(values []string, value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		values = append(values, v)
+	case map[string]interface{}:
+		for _, item := range v {
+			values = appendStrings(values, item)
+		}
+	case []interface{}:
+		for _, item := range v {
+			values = appendStrings(values, item)
+		}
+	}
+	return values
+}
+
+// secretExtraVars extracts the values of the named variables from
+// key=value and JSON formatted extra vars.
+func secretExtraVars(extraVars, names []string) []string {
+	var (
+		secrets []string
+		secret  = map[string]bool{}
+	)
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	for _, name := range names {
+		secret[name] = true
+	}
+
+	for _, ev := range extraVars {
+		if strings.HasPrefix(strings.TrimSpace(ev), "{") {
+			vars := map[string]interface{}{}
+
+			if err := json.Unmarshal([]byte(ev), &vars); err == nil {
+				for key, value := range vars {
+					if value, ok := value.(string); ok && secret[key] {
+						secrets = append(secrets, value)
+					}
+				}
+			}
+
+			continue
+		}
+
+		for _, field := range strings.Fields(ev) {
+			parts := strings.SplitN(field, "=", 2)
+
+			if len(parts) == 2 && secret[parts[0]] {
+				secrets = append(secrets, strings.Trim(parts[1], `"'`))
+			}
+		}
+	}
+
+	return secrets
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// writeOutputs appends the outcome of the run as dotenv formatted step
+// outputs, so later pipeline steps can react on changed or failed hosts.
+func (p *Plugin) writeOutputs(duration time.Duration, runErr error) error {
+	var (
+		changed      []string
+		failed       []string
+		changedCount int
+		seen         = map[string]bool{}
+		failedSeen   = map[string]bool{}
+	)
+
+	for _, result := range p.Results {
+		for _, h := range result.Hosts {
+			changedCount += h.Changed
+
+			if h.Changed > 0 && !seen[h.Host] {
+				seen[h.Host] = true
+				changed = append(changed, h.Host)
+			}
+
+			if !h.Success() && !failedSeen[h.Host] {
+				failedSeen[h.Host] = true
+				failed = append(failed, h.Host)
+			}
+		}
+	}
+
+	outputs := [][2]string{
+		{"status", status(runErr == nil)},
+		{"changed_hosts", strings.Join(changed, ",")},
+		{"failed_hosts", strings.Join(failed, ",")},
+		{"changed_count", strconv.Itoa(changedCount)},
+		{"duration", strconv.Itoa(int(duration.Round(time.Second).Seconds()))},
+	}
+
+	file, err := os.OpenFile(p.Config.OutputsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to open outputs file")
+	}
+
+	for _, output := range outputs {
+		if _, err := fmt.Fprintf(file, "%s=%s\n", output[0], output[1]); err != nil {
+			file.Close()
+			return errors.Wrap(err, "failed to write outputs file")
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		return errors.Wrap(err, "failed to close outputs file")
+	}
+
+	return nil
+}
@@ -0,0 +1,704 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// Command is a planned invocation built purely from the Config. The
+	// executors turn it into an exec.Cmd, creating the files it needs.
+	Command struct {
+		Executable string
+		Args       []string
+		Env        []string
+		Files      []CommandFile
+	}
+
+	// CommandFile is a temporary file created before the command runs. The
+	// arguments and the environment reference its path with fileRef.
+	CommandFile struct {
+		Name    string // Name referenced by fileRef
+		Prefix  string // Pattern of the temporary file name
+		Dir     string // Directory to create the file in, the temp dir by default
+		Content string
+		Show    bool // Print the content in dry runs
+	}
+)
+
+// fileRef returns the placeholder which is replaced by the path of the
+// named file.
+func fileRef(name string) string {
+	return "{file:" + name + "}"
+}
+
+// add appends the arguments together with the files they reference.
+func (c *Command) add(args []string, files []CommandFile) {
+	c.Args = append(c.Args, args...)
+	c.Files = append(c.Files, files...)
+}
+
+// command creates the files of the plan and returns the command to run.
+func (p *Plugin) command(plan Command) (*exec.Cmd, error) {
+	var paths []string
+
+	for _, file := range plan.Files {
+		path, err := p.writeCommandFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, fileRef(file.Name), path)
+	}
+
+	refs := strings.NewReplacer(paths...)
+
+	args := make([]string, len(plan.Args))
+	for i, arg := range plan.Args {
+		args[i] = refs.Replace(arg)
+	}
+
+	cmd := exec.Command(p.executable(plan.Executable), args...)
+	cmd.Env = p.env()
+
+	for _, env := range plan.Env {
+		cmd.Env = append(cmd.Env, refs.Replace(env))
+	}
+
+	return cmd, nil
+}
+
+// writeCommandFile creates a file of a plan, it is only readable by the
+// current user and removed once the plugin finishes.
+func (p *Plugin) writeCommandFile(file CommandFile) (string, error) {
+	if file.Dir != "" {
+		if err := ensureDirectoryExists(file.Dir); err != nil {
+			return "", errors.Wrapf(err, "failed to create %s directory", file.Name)
+		}
+	}
+
+	tmpfile, err := os.CreateTemp(file.Dir, file.Prefix)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create %s file", file.Name)
+	}
+
+	p.removeLater(tmpfile.Name())
+
+	if file.Show {
+		p.showLater(tmpfile.Name())
+	}
+
+	if _, err := tmpfile.WriteString(file.Content); err != nil {
+		tmpfile.Close()
+		return "", errors.Wrapf(err, "failed to write %s file", file.Name)
+	}
+
+	if err := tmpfile.Close(); err != nil {
+		return "", errors.Wrapf(err, "failed to close %s file", file.Name)
+	}
+
+	return tmpfile.Name(), nil
+}
+
+// withAnsibleConfig points the command to the generated ansible.cfg.
+func (c Config) withAnsibleConfig(plan Command) Command {
+	if c.GeneratedConfig == "" {
+		return plan
+	}
+
+	plan.Env = append(plan.Env, "ANSIBLE_CONFIG="+fileRef("ansible.cfg"))
+	plan.Files = append(plan.Files, CommandFile{
+		Name:    "ansible.cfg",
+		Prefix:  ".ansible-*.cfg",
+		Dir:     c.GeneratedConfigDir,
+		Content: c.GeneratedConfig,
+		Show:    true,
+	})
+
+	return plan
+}
+
+func (c Config) versionCommand() Command {
+	return c.withAnsibleConfig(Command{
+		Executable: "ansible",
+		Args: []string{
+			"--version",
+		},
+		Env: []string{
+			"ANSIBLE_FORCE_COLOR=1",
+		},
+	})
+}
+
+func (c Config) requirementsCommand() Command {
+	args := []string{
+		"install",
+		"--upgrade",
+		"--requirement",
+		c.Requirements,
+	}
+
+	for _, constraint := range c.Constraints {
+		args = append(args, "--constraint", constraint)
+	}
+
+	return Command{
+		Executable: "pip",
+		Args:       args,
+		Env: []string{
+			"ANSIBLE_FORCE_COLOR=1",
+		},
+	}
+}
+
+// virtualenvCommand plans the creation of a virtualenv in dir.
+func (c Config) virtualenvCommand(dir string) Command {
+	return Command{
+		Executable: c.VirtualenvPython,
+		Args: []string{
+			"-m",
+			"venv",
+			dir,
+		},
+	}
+}
+
+func (c Config) pythonVersionCommand() Command {
+	return Command{
+		Executable: c.VirtualenvPython,
+		Args: []string{
+			"--version",
+		},
+	}
+}
+
+// galaxyCommands plans the installation of the roles and the collections
+// of the galaxy requirements file.
+func (c Config) galaxyCommands(req *GalaxyRequirements) []Command {
+	var commands []Command
+
+	if len(req.Roles) > 0 {
+		args := []string{
+			"role",
+			"install",
+		}
+
+		if c.GalaxyForce {
+			args = append(args, "--force")
+		}
+
+		args = append(args,
+			"--role-file",
+			c.Galaxy,
+		)
+
+		if c.GalaxyRolesPath != "" {
+			args = append(args, "--roles-path", c.GalaxyRolesPath)
+		}
+
+		if c.GalaxyServer != "" {
+			args = append(args, "--server", c.GalaxyServer)
+		}
+
+		commands = append(commands, c.withAnsibleConfig(Command{
+			Executable: "ansible-galaxy",
+			Args:       append(args, c.verboseArgs()...),
+			Env: []string{
+				"ANSIBLE_FORCE_COLOR=1",
+			},
+		}))
+	}
+
+	if len(req.Collections) > 0 {
+		args := []string{
+			"collection",
+			"install",
+		}
+
+		if c.GalaxyForce {
+			args = append(args, "--force")
+		}
+
+		args = append(args,
+			"--requirements-file",
+			c.Galaxy,
+		)
+
+		if c.GalaxyCollectionsPath != "" {
+			args = append(args, "--collections-path", c.GalaxyCollectionsPath)
+		}
+
+		if c.GalaxyServer != "" {
+			args = append(args, "--server", c.GalaxyServer)
+		}
+
+		if c.GalaxyOffline {
+			args = append(args, "--offline")
+		}
+
+		commands = append(commands, c.withAnsibleConfig(Command{
+			Executable: "ansible-galaxy",
+			Args:       append(args, c.verboseArgs()...),
+			Env: []string{
+				"ANSIBLE_FORCE_COLOR=1",
+			},
+		}))
+	}
+
+	return commands
+}
+
+// playbookCommand plans ansible-playbook against a single inventory. Retry
+// hosts limit the run to the hosts which failed before.
+func (c Config) playbookCommand(inventory string, retry []string) Command {
+	plan := Command{
+		Executable: "ansible-playbook",
+		Env: []string{
+			"ANSIBLE_FORCE_COLOR=1",
+		},
+	}
+
+	plan.add(c.inventoryArgs(inventory))
+
+	if len(c.ModulePath) > 0 {
+		plan.Args = append(plan.Args, "--module-path", strings.Join(c.ModulePath, ":"))
+	}
+
+	plan.add(c.vaultArgs())
+	plan.add(c.extraVarsArgs())
+
+	if c.ListHosts {
+		plan.Args = append(plan.Args, "--list-hosts")
+		plan.Args = append(plan.Args, c.Playbooks...)
+
+		return c.withAnsibleConfig(plan)
+	}
+
+	if c.SyntaxCheck {
+		plan.Args = append(plan.Args, "--syntax-check")
+		plan.Args = append(plan.Args, c.Playbooks...)
+
+		return c.withAnsibleConfig(plan)
+	}
+
+	if c.Check {
+		plan.Args = append(plan.Args, "--check")
+	}
+
+	if c.Diff {
+		plan.Args = append(plan.Args, "--diff")
+	}
+
+	if c.FlushCache {
+		plan.Args = append(plan.Args, "--flush-cache")
+	}
+
+	if c.ForceHandlers {
+		plan.Args = append(plan.Args, "--force-handlers")
+	}
+
+	plan.Args = append(plan.Args, c.forksArgs()...)
+
+	switch {
+	case len(retry) > 0:
+		plan.Args = append(plan.Args, "--limit", "@"+fileRef("retry"))
+		plan.Files = append(plan.Files, CommandFile{
+			Name:    "retry",
+			Prefix:  "retry",
+			Content: strings.Join(retry, "\n") + "\n",
+		})
+	case c.Limit != "":
+		plan.Args = append(plan.Args, "--limit", c.Limit)
+	}
+
+	if c.ListTags {
+		plan.Args = append(plan.Args, "--list-tags")
+	}
+
+	if c.ListTasks {
+		plan.Args = append(plan.Args, "--list-tasks")
+	}
+
+	if c.SkipTags != "" {
+		plan.Args = append(plan.Args, "--skip-tags", c.SkipTags)
+	}
+
+	if c.StartAtTask != "" {
+		plan.Args = append(plan.Args, "--start-at-task", c.StartAtTask)
+	}
+
+	if c.Tags != "" {
+		plan.Args = append(plan.Args, "--tags", c.Tags)
+	}
+
+	plan.add(c.connectionArgs())
+	plan.Args = append(plan.Args, c.verboseArgs()...)
+
+	// a custom installation replaces ansible-playbook
+	if c.Installation != "" {
+		plan.Executable = c.Installation
+	}
+
+	plan.Args = append(plan.Args, c.Playbooks...)
+
+	return c.withAnsibleConfig(plan)
+}
+
+// adhocCommand plans an ansible ad-hoc command.
+func (c Config) adhocCommand() Command {
+	// Default to 'command' module if no module is provided
+	module := c.Module
+	if module == "" {
+		module = "command"
+	}
+
+	hostKeyChecking := "False"
+	if c.HostKeyChecking {
+		hostKeyChecking = "True"
+	}
+
+	plan := Command{
+		Executable: "ansible",
+		Args: []string{
+			c.Hosts,      // Target hosts
+			"-m", module, // Module to execute
+		},
+		Env: []string{
+			"ANSIBLE_HOST_KEY_CHECKING=" + hostKeyChecking,
+		},
+	}
+
+	if c.ModuleArguments != "" {
+		plan.Args = append(plan.Args, "-a", c.ModuleArguments)
+	}
+
+	for _, inventory := range c.Inventories {
+		plan.add(c.inventoryArgs(inventory))
+	}
+
+	if c.InventoryContent != "" {
+		plan.add(c.inventoryArgs(InlineInventory))
+	}
+
+	if len(c.ModulePath) > 0 {
+		plan.Args = append(plan.Args, "--module-path", strings.Join(c.ModulePath, ":"))
+	}
+	if c.Check {
+		plan.Args = append(plan.Args, "--check")
+	}
+	if c.Diff {
+		plan.Args = append(plan.Args, "--diff")
+	}
+
+	if c.DynamicInventory {
+		plan.Args = append(plan.Args, "--dynamic-inventory")
+	}
+
+	plan.add(c.extraVarsArgs())
+
+	if c.Extras != "" {
+		plan.Args = append(plan.Args, c.Extras)
+	}
+
+	plan.Args = append(plan.Args, c.forksArgs()...)
+
+	plan.add(c.vaultArgs())
+	plan.add(c.connectionArgs())
+
+	if c.VaultCredentialsKey != "" {
+		plan.Args = append(plan.Args, "--vault-password-file", fileRef("vault-credentials"))
+		plan.Files = append(plan.Files, CommandFile{
+			Name:    "vault-credentials",
+			Prefix:  "vault-pass",
+			Dir:     c.VaultTmpPath,
+			Content: c.VaultCredentialsKey,
+		})
+	}
+
+	if c.Installation != "" {
+		plan.Executable = c.Installation
+	}
+
+	return c.withAnsibleConfig(plan)
+}
+
+func (c Config) inventoryCommand() Command {
+	plan := Command{
+		Executable: "ansible-inventory",
+	}
+
+	for _, inventory := range c.Inventories {
+		plan.add(c.inventoryArgs(inventory))
+	}
+
+	plan.add(c.vaultArgs())
+
+	if c.Limit != "" {
+		plan.Args = append(plan.Args, "--limit", c.Limit)
+	}
+
+	switch c.InventoryAction {
+	case InventoryGraph:
+		plan.Args = append(plan.Args, "--graph")
+	case InventoryHost:
+		plan.Args = append(plan.Args, "--host", c.InventoryHost)
+	default:
+		plan.Args = append(plan.Args, "--list")
+	}
+
+	return c.withAnsibleConfig(plan)
+}
+
+func (c Config) lintCommand() Command {
+	args := []string{
+		"--format",
+		"json",
+		"--nocolor",
+	}
+
+	args = append(args, c.verboseArgs()...)
+	args = append(args, c.Playbooks...)
+
+	return c.withAnsibleConfig(Command{
+		Executable: "ansible-lint",
+		Args:       args,
+	})
+}
+
+// vaultCommand plans ansible-vault for a single file, encrypt_string gets
+// its content on stdin instead.
+func (c Config) vaultCommand(file string) Command {
+	plan := Command{
+		Executable: "ansible-vault",
+		Args: []string{
+			c.Action,
+		},
+	}
+
+	if c.Installation != "" {
+		plan.Executable = c.Installation
+	}
+
+	if file != "" {
+		plan.Args = append(plan.Args, file)
+	}
+
+	if c.Output != "" {
+		plan.Args = append(plan.Args, "--output", c.Output)
+	}
+
+	if c.VaultCredentialsKey != "" || len(c.VaultIDs) == 0 {
+		plan.Args = append(plan.Args, "--vault-password-file", fileRef("vault-credentials"))
+		plan.Files = append(plan.Files, CommandFile{
+			Name:    "vault-credentials",
+			Prefix:  "vault-pass",
+			Dir:     c.VaultTmpPath,
+			Content: c.VaultCredentialsKey,
+		})
+	}
+
+	if len(c.VaultIDs) > 0 {
+		plan.add(c.vaultIDArgs())
+
+		label := strings.SplitN(c.VaultID, "@", 2)[0]
+		if _, ok := c.VaultIDs[label]; ok && (c.Action == ActionEncrypt || c.Action == ActionEncryptString) {
+			plan.Args = append(plan.Args, "--encrypt-vault-id", label)
+		}
+	}
+
+	if c.Action == ActionRekey {
+		switch {
+		case c.NewVaultID != "":
+			// the label names the identity the content is encrypted with
+			plan.Args = append(plan.Args, "--new-vault-id", c.NewVaultID+"@"+fileRef("new-vault-password"))
+			plan.Files = append(plan.Files, CommandFile{
+				Name:    "new-vault-password",
+				Prefix:  "new-vault-pass",
+				Dir:     c.VaultTmpPath,
+				Content: c.VaultIDs[c.NewVaultID],
+			})
+		case c.NewVaultCredentialsKey != "":
+			plan.Args = append(plan.Args, "--new-vault-password-file", fileRef("new-vault-password"))
+			plan.Files = append(plan.Files, CommandFile{
+				Name:    "new-vault-password",
+				Prefix:  "new-vault-pass",
+				Dir:     c.VaultTmpPath,
+				Content: c.NewVaultCredentialsKey,
+			})
+		}
+	}
+
+	return c.withAnsibleConfig(plan)
+}
+
+// inventoryArgs passes a single inventory, the inline inventory content is
+// written to a file.
+func (c Config) inventoryArgs(inventory string) ([]string, []CommandFile) {
+	if inventory != InlineInventory {
+		return []string{"--inventory", inventory}, nil
+	}
+
+	return []string{"--inventory", fileRef("inventory")}, []CommandFile{{
+		Name:    "inventory",
+		Prefix:  "inventory",
+		Content: c.InventoryContent,
+	}}
+}
+
+// extraVarsArgs passes the merged extra vars object as a file first, so the
+// key=value extra vars afterwards take precedence.
+func (c Config) extraVarsArgs() ([]string, []CommandFile) {
+	var (
+		args  []string
+		files []CommandFile
+	)
+
+	if len(c.ExtraVarsMap) > 0 {
+		// loadExtraVars made sure the extra vars can be encoded
+		content, _ := json.Marshal(c.ExtraVarsMap)
+
+		args = append(args, "--extra-vars", "@"+fileRef("extra-vars"))
+		files = append(files, CommandFile{
+			Name:    "extra-vars",
+			Prefix:  "extra-vars-*.json",
+			Content: string(content),
+			Show:    true,
+		})
+	}
+
+	for _, v := range c.ExtraVars {
+		args = append(args, "--extra-vars", v)
+	}
+
+	return args, files
+}
+
+// vaultIDArgs returns a --vault-id label@file argument per vault identity
+func (c Config) vaultIDArgs() ([]string, []CommandFile) {
+	var (
+		args  []string
+		files []CommandFile
+	)
+
+	for _, label := range sortedKeys(c.VaultIDs) {
+		name := "vault-id-" + label
+
+		args = append(args, "--vault-id", label+"@"+fileRef(name))
+		files = append(files, CommandFile{
+			Name:    name,
+			Prefix:  "vault-id",
+			Dir:     c.VaultTmpPath,
+			Content: c.VaultIDs[label],
+		})
+	}
+
+	return args, files
+}
+
+// vaultArgs returns the vault identities and password files shared by all
+// ansible commands.
+func (c Config) vaultArgs() ([]string, []CommandFile) {
+	var args []string
+
+	// a vault_id naming one of the vault_ids only selects the identity
+	if _, ok := c.VaultIDs[c.VaultID]; c.VaultID != "" && !ok {
+		args = append(args, "--vault-id", c.VaultID)
+	}
+
+	idArgs, files := c.vaultIDArgs()
+	args = append(args, idArgs...)
+
+	if c.VaultPassword != "" {
+		args = append(args, "--vault-password-file", fileRef("vault-password"))
+		files = append(files, CommandFile{
+			Name:    "vault-password",
+			Prefix:  "vaultPass",
+			Dir:     c.VaultTmpPath,
+			Content: c.VaultPassword,
+		})
+	}
+
+	return args, files
+}
+
+// forksArgs returns the parallelism shared by ansible-playbook and ansible.
+// The default of 5 is left out, so the forks of the ansible.cfg apply.
+func (c Config) forksArgs() []string {
+	if c.Forks <= 0 || c.Forks == 5 {
+		return nil
+	}
+	return []string{"--forks", strconv.Itoa(c.Forks)}
+}
+
+// connectionArgs returns the connection, authentication and privilege
+// escalation options shared by ansible-playbook and ansible.
+func (c Config) connectionArgs() ([]string, []CommandFile) {
+	var (
+		args  []string
+		files []CommandFile
+	)
+
+	if c.PrivateKey != "" {
+		args = append(args, "--private-key", fileRef("private-key"))
+		files = append(files, CommandFile{
+			Name:    "private-key",
+			Prefix:  "privateKey",
+			Content: c.PrivateKey,
+		})
+	}
+
+	if c.User != "" {
+		args = append(args, "--user", c.User)
+	}
+
+	if c.Connection != "" {
+		args = append(args, "--connection", c.Connection)
+	}
+
+	if c.Timeout != 0 {
+		args = append(args, "--timeout", strconv.Itoa(c.Timeout))
+	}
+
+	if c.SSHCommonArgs != "" {
+		args = append(args, "--ssh-common-args", c.SSHCommonArgs)
+	}
+
+	if c.SFTPExtraArgs != "" {
+		args = append(args, "--sftp-extra-args", c.SFTPExtraArgs)
+	}
+
+	if c.SCPExtraArgs != "" {
+		args = append(args, "--scp-extra-args", c.SCPExtraArgs)
+	}
+
+	if c.SSHExtraArgs != "" {
+		args = append(args, "--ssh-extra-args", c.SSHExtraArgs)
+	}
+
+	if c.Become {
+		args = append(args, "--become")
+	}
+
+	if c.BecomeMethod != "" {
+		args = append(args, "--become-method", c.BecomeMethod)
+	}
+
+	if c.BecomeUser != "" {
+		args = append(args, "--become-user", c.BecomeUser)
+	}
+
+	return args, files
+}
+
+func (c Config) verboseArgs() []string {
+	if c.Verbose <= 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("-%s", strings.Repeat("v", c.Verbose))}
+}
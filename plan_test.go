@@ -0,0 +1,481 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// renderCommands prints the plans in a stable, reviewable form.
+func renderCommands(plans ...Command) string {
+	var b strings.Builder
+
+	for i, plan := range plans {
+		if i > 0 {
+			b.WriteString("---\n")
+		}
+
+		fmt.Fprintf(&b, "executable: %s\n", plan.Executable)
+
+		b.WriteString("args:\n")
+		for _, arg := range plan.Args {
+			fmt.Fprintf(&b, "  %s\n", arg)
+		}
+
+		if len(plan.Env) > 0 {
+			b.WriteString("env:\n")
+			for _, env := range plan.Env {
+				fmt.Fprintf(&b, "  %s\n", env)
+			}
+		}
+
+		for _, file := range plan.Files {
+			fmt.Fprintf(&b, "file: %s\n", fileRef(file.Name))
+			fmt.Fprintf(&b, "  prefix: %s\n", file.Prefix)
+
+			if file.Dir != "" {
+				fmt.Fprintf(&b, "  dir: %s\n", file.Dir)
+			}
+
+			if file.Show {
+				b.WriteString("  show: true\n")
+			}
+
+			b.WriteString("  content: |\n")
+			for _, line := range strings.Split(strings.TrimRight(file.Content, "\n"), "\n") {
+				fmt.Fprintf(&b, "    %s\n", line)
+			}
+		}
+	}
+
+	return b.String()
+}
+
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+
+	path := filepath.Join("testdata", "plan", name+".golden")
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file, run go test -update: %s", err)
+	}
+
+	if got != string(want) {
+		t.Errorf("%s does not match the plan:\n--- want\n%s\n--- got\n%s", path, want, got)
+	}
+}
+
+// allConnectionFlags sets every connection, authentication and privilege
+// escalation option.
+func allConnectionFlags(c Config) Config {
+	c.PrivateKey = "-----BEGIN KEY-----\nsecret\n-----END KEY-----\n"
+	c.User = "deploy"
+	c.Connection = "ssh"
+	c.Timeout = 30
+	c.SSHCommonArgs = "-o ProxyJump=bastion"
+	c.SFTPExtraArgs = "-l 1000"
+	c.SCPExtraArgs = "-l 2000"
+	c.SSHExtraArgs = "-o ServerAliveInterval=10"
+	c.Become = true
+	c.BecomeMethod = "sudo"
+	c.BecomeUser = "root"
+
+	return c
+}
+
+// allVaultFlags sets every vault option shared by the ansible commands.
+func allVaultFlags(c Config) Config {
+	c.VaultID = "dev@prompt"
+	c.VaultIDs = map[string]string{
+		"prod":    "prod-secret",
+		"staging": "staging-secret",
+	}
+	c.VaultPassword = "vault-secret"
+
+	return c
+}
+
+func TestPlaybookCommand(t *testing.T) {
+	base := Config{
+		Playbooks: []string{"site.yml"},
+		Forks:     5,
+	}
+
+	all := allVaultFlags(allConnectionFlags(base))
+	all.Playbooks = []string{"site.yml", "deploy.yml"}
+	all.ModulePath = []string{"library", "plugins/modules"}
+	all.ExtraVars = []string{"version=1.2.3", "env=prod"}
+	all.ExtraVarsMap = map[string]interface{}{"app": map[string]interface{}{"port": 8080}}
+	all.Check = true
+	all.Diff = true
+	all.FlushCache = true
+	all.ForceHandlers = true
+	all.Forks = 10
+	all.Limit = "web"
+	all.ListTags = true
+	all.ListTasks = true
+	all.SkipTags = "slow"
+	all.StartAtTask = "configure"
+	all.Tags = "deploy,config"
+	all.Verbose = 3
+	all.Installation = "/opt/ansible/bin/ansible-playbook"
+	all.GeneratedConfig = "[defaults]\nforks = 10\n"
+	all.GeneratedConfigDir = "/drone/src"
+
+	listHosts := all
+	listHosts.ListHosts = true
+
+	syntaxCheck := all
+	syntaxCheck.SyntaxCheck = true
+
+	inline := base
+	inline.InventoryContent = "[web]\nweb1\nweb2\n"
+
+	vaultTmpPath := allVaultFlags(base)
+	vaultTmpPath.VaultTmpPath = "/run/vault"
+
+	selected := base
+	selected.VaultID = "prod"
+	selected.VaultIDs = map[string]string{"prod": "prod-secret"}
+
+	tests := []struct {
+		name      string
+		config    Config
+		inventory string
+		retry     []string
+	}{
+		{"minimal", base, "hosts", nil},
+		{"all_flags", all, "hosts", nil},
+		{"list_hosts", listHosts, "hosts", nil},
+		{"syntax_check", syntaxCheck, "hosts", nil},
+		{"inline_inventory", inline, InlineInventory, nil},
+		{"retry", all, "hosts", []string{"web1", "web2"}},
+		{"vault_tmp_path", vaultTmpPath, "hosts", nil},
+		{"vault_id_selects_identity", selected, "hosts", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertGolden(t, "playbook_"+tt.name, renderCommands(tt.config.playbookCommand(tt.inventory, tt.retry)))
+		})
+	}
+}
+
+func TestAdhocCommand(t *testing.T) {
+	base := Config{
+		Hosts: "all",
+	}
+
+	all := allVaultFlags(allConnectionFlags(base))
+	all.Module = "shell"
+	all.ModuleArguments = "uptime"
+	all.Inventories = []string{"hosts", "extra"}
+	all.InventoryContent = "[web]\nweb1\n"
+	all.ModulePath = []string{"library"}
+	all.Check = true
+	all.Diff = true
+	all.DynamicInventory = true
+	all.ExtraVars = []string{"version=1.2.3"}
+	all.ExtraVarsMap = map[string]interface{}{"app": "demo"}
+	all.Extras = "--one-line"
+	all.Forks = 10
+	all.VaultCredentialsKey = "credentials-secret"
+	all.HostKeyChecking = true
+	all.Installation = "/opt/ansible/bin/ansible"
+	all.GeneratedConfig = "[defaults]\nhost_key_checking = False\n"
+	all.GeneratedConfigDir = "/drone/src"
+
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"minimal", base},
+		{"all_flags", all},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertGolden(t, "adhoc_"+tt.name, renderCommands(tt.config.adhocCommand()))
+		})
+	}
+}
+
+func TestGalaxyCommands(t *testing.T) {
+	roles := &GalaxyRequirements{
+		Roles: []*GalaxyRequirement{{Name: "geerlingguy.nginx"}},
+	}
+
+	collections := &GalaxyRequirements{
+		Collections: []*GalaxyRequirement{{Name: "community.general"}},
+	}
+
+	both := &GalaxyRequirements{
+		Roles:       roles.Roles,
+		Collections: collections.Collections,
+	}
+
+	base := Config{
+		Galaxy: "requirements.yml",
+	}
+
+	all := base
+	all.GalaxyForce = true
+	all.GalaxyRolesPath = "/cache/roles"
+	all.GalaxyCollectionsPath = "/cache/collections"
+	all.GalaxyServer = "https://galaxy.example.com"
+	all.GalaxyOffline = true
+	all.Verbose = 2
+	all.GeneratedConfig = "[galaxy]\nignore_certs = True\n"
+	all.GeneratedConfigDir = "/drone/src"
+
+	tests := []struct {
+		name   string
+		config Config
+		req    *GalaxyRequirements
+	}{
+		{"empty", base, &GalaxyRequirements{}},
+		{"roles", base, roles},
+		{"collections", base, collections},
+		{"both", base, both},
+		{"all_flags", all, both},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertGolden(t, "galaxy_"+tt.name, renderCommands(tt.config.galaxyCommands(tt.req)...))
+		})
+	}
+}
+
+func TestRequirementsCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"plain", Config{Requirements: "requirements.txt"}},
+		{"constraints", Config{Requirements: "requirements.txt", Constraints: []string{"constraints.txt", "pins.txt"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertGolden(t, "requirements_"+tt.name, renderCommands(tt.config.requirementsCommand()))
+		})
+	}
+}
+
+func TestInventoryCommand(t *testing.T) {
+	base := Config{
+		Inventories: []string{"hosts"},
+	}
+
+	graph := allVaultFlags(base)
+	graph.InventoryAction = InventoryGraph
+	graph.GeneratedConfig = "[inventory]\nenable_plugins = ini\n"
+	graph.GeneratedConfigDir = "/drone/src"
+
+	host := base
+	host.Inventories = []string{"hosts", InlineInventory}
+	host.InventoryContent = "[web]\nweb1\n"
+	host.InventoryAction = InventoryHost
+	host.InventoryHost = "web1"
+	host.Limit = "web"
+
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"list", base},
+		{"graph", graph},
+		{"host", host},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertGolden(t, "inventory_"+tt.name, renderCommands(tt.config.inventoryCommand()))
+		})
+	}
+}
+
+func TestLintCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"minimal", Config{Playbooks: []string{"site.yml"}}},
+		{"verbose", Config{Playbooks: []string{"site.yml", "deploy.yml"}, Verbose: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertGolden(t, "lint_"+tt.name, renderCommands(tt.config.lintCommand()))
+		})
+	}
+}
+
+func TestVaultCommand(t *testing.T) {
+	ids := map[string]string{"dev": "dev-secret", "prod": "prod-secret"}
+
+	tests := []struct {
+		name   string
+		config Config
+		file   string
+	}{
+		{"encrypt", Config{Action: ActionEncrypt, VaultCredentialsKey: "vault-secret"}, "secrets.yml"},
+		{"encrypt_vault_id", Config{Action: ActionEncrypt, VaultIDs: ids, VaultID: "prod"}, "secrets.yml"},
+		{"encrypt_string", Config{Action: ActionEncryptString, VaultCredentialsKey: "vault-secret", Output: "secret.txt"}, ""},
+		{"rekey", Config{Action: ActionRekey, VaultCredentialsKey: "vault-secret", NewVaultCredentialsKey: "new-secret"}, "secrets.yml"},
+		{"rekey_vault_id", Config{Action: ActionRekey, VaultIDs: ids, NewVaultID: "prod"}, "secrets.yml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertGolden(t, "vault_"+tt.name, renderCommands(tt.config.vaultCommand(tt.file)))
+		})
+	}
+}
+
+// optionValues collects the occurrences of an option in the arguments, the
+// value for options taking one and the option itself for switches.
+func optionValues(args []string, option string, takesValue bool) []string {
+	var values []string
+
+	for i, arg := range args {
+		if arg != option {
+			continue
+		}
+
+		switch {
+		case !takesValue:
+			values = append(values, arg)
+		case i+1 < len(args):
+			values = append(values, args[i+1])
+		}
+	}
+
+	return values
+}
+
+// fileContents maps the referenced files of a plan to their content.
+func fileContents(plan Command) map[string]string {
+	contents := map[string]string{}
+
+	for _, file := range plan.Files {
+		contents[fileRef(file.Name)] = file.Content
+	}
+
+	return contents
+}
+
+func TestAdhocPlaybookParity(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		options []string
+	}{
+		{"private key", func(c *Config) { c.PrivateKey = "key" }, []string{"--private-key"}},
+		{"user", func(c *Config) { c.User = "deploy" }, []string{"--user"}},
+		{"connection", func(c *Config) { c.Connection = "paramiko" }, []string{"--connection"}},
+		{"timeout", func(c *Config) { c.Timeout = 42 }, []string{"--timeout"}},
+		{"forks", func(c *Config) { c.Forks = 10 }, []string{"--forks"}},
+		{"become", func(c *Config) {
+			c.Become = true
+			c.BecomeMethod = "su"
+			c.BecomeUser = "admin"
+		}, []string{"--become", "--become-method", "--become-user"}},
+		{"ssh args", func(c *Config) {
+			c.SSHCommonArgs = "-o ProxyJump=bastion"
+			c.SFTPExtraArgs = "-l 1000"
+			c.SCPExtraArgs = "-l 2000"
+			c.SSHExtraArgs = "-o ServerAliveInterval=10"
+		}, []string{"--ssh-common-args", "--sftp-extra-args", "--scp-extra-args", "--ssh-extra-args"}},
+		{"module path", func(c *Config) { c.ModulePath = []string{"library", "plugins"} }, []string{"--module-path"}},
+		{"check and diff", func(c *Config) {
+			c.Check = true
+			c.Diff = true
+		}, []string{"--check", "--diff"}},
+		{"vault password", func(c *Config) { c.VaultPassword = "secret" }, []string{"--vault-password-file"}},
+		{"vault ids", func(c *Config) {
+			c.VaultIDs = map[string]string{"prod": "prod-secret", "dev": "dev-secret"}
+		}, []string{"--vault-id"}},
+		{"vault id", func(c *Config) { c.VaultID = "dev@prompt" }, []string{"--vault-id"}},
+	}
+
+	switches := map[string]bool{
+		"--become": true,
+		"--check":  true,
+		"--diff":   true,
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{
+				Hosts:       "all",
+				Inventories: []string{"hosts"},
+				Playbooks:   []string{"site.yml"},
+				Forks:       5,
+			}
+
+			tt.modify(&config)
+
+			adhoc := config.adhocCommand()
+			playbook := config.playbookCommand("hosts", nil)
+
+			adhocFiles := fileContents(adhoc)
+			playbookFiles := fileContents(playbook)
+
+			for _, option := range tt.options {
+				got := optionValues(adhoc.Args, option, !switches[option])
+				want := optionValues(playbook.Args, option, !switches[option])
+
+				if len(want) == 0 {
+					t.Fatalf("playbook plan is missing %s", option)
+				}
+
+				if strings.Join(got, "\n") != strings.Join(want, "\n") {
+					t.Errorf("%s differs: adhoc %q, playbook %q", option, got, want)
+				}
+
+				for _, value := range want {
+					for ref, content := range playbookFiles {
+						if strings.Contains(value, ref) && adhocFiles[ref] != content {
+							t.Errorf("%s file %s differs: adhoc %q, playbook %q", option, ref, adhocFiles[ref], content)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDefaultForks(t *testing.T) {
+	for _, forks := range []int{0, 5} {
+		config := Config{
+			Hosts:       "all",
+			Inventories: []string{"hosts"},
+			Playbooks:   []string{"site.yml"},
+			Forks:       forks,
+		}
+
+		for _, plan := range []Command{config.adhocCommand(), config.playbookCommand("hosts", nil)} {
+			if values := optionValues(plan.Args, "--forks", true); len(values) > 0 {
+				t.Errorf("%s passes --forks %v for forks %d", plan.Executable, values, forks)
+			}
+		}
+	}
+}
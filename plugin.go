@@ -1,30 +1,32 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strconv"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
-var ansibleFolder = "/etc/ansible"
-var ansibleConfig = "/etc/ansible/ansible.cfg"
-
-// var ansibleContent = `
-// [defaults]
-// host_key_checking = False
-// `
-
 const (
-	ModePlaybook = "playbook"
-	ModeAdhoc    = "adhoc"
-	ModeVault    = "vault"
+	ModePlaybook  = "playbook"
+	ModeAdhoc     = "adhoc"
+	ModeVault     = "vault"
+	ModeLint      = "lint"
+	ModeInventory = "inventory"
+	ModeDrift     = "drift"
 )
 
+// InlineInventory stands for the inventory_content setting in the inventories
+const InlineInventory = "inventory_content"
+
 // Constants for valid actions
 const (
 	ActionEncrypt       = "encrypt"
@@ -33,12 +35,17 @@ const (
 	ActionView          = "view"
 	ActionEdit          = "edit"
 	ActionRekey         = "rekey"
+	ActionVerify        = "verify"
 )
 
 type (
 	Config struct {
 		Mode                   string
 		Requirements           string
+		Constraints            []string // Pip constraints files applied to the requirements
+		Virtualenv             bool     // Install the requirements into an isolated virtualenv
+		VirtualenvPython       string   // Python interpreter creating the virtualenv
+		VirtualenvCacheDir     string   // Directory caching virtualenvs by requirements hash
 		Galaxy                 string
 		Inventories            []string
 		Playbooks              []string
@@ -47,8 +54,21 @@ type (
 		StartAtTask            string
 		Tags                   string
 		ExtraVars              []string
+		ExtraVarsMap           map[string]interface{} // Extra vars object, merged with the extra vars files
+		ExtraVarsFiles         []string
+		AnsibleConfig          map[string]map[string]string
+		GeneratedConfig        string // ansible.cfg merged from the repository config and the config setting
+		GeneratedConfigDir     string // Directory of the repository config, the generated one is written there
 		ModulePath             []string
 		GalaxyForce            bool
+		GalaxyRolesPath        string // Directory to install galaxy roles to
+		GalaxyCollectionsPath  string // Directory to install galaxy collections to
+		GalaxyServer           string // Galaxy server to install from
+		GalaxyOffline          bool   // Install collections without contacting a galaxy server
+		GalaxyCacheDir         string // Directory caching installed roles and collections by requirements hash
+		GalaxyOfflineDir       string // Directory with namespace-name-version.tar.gz artifacts to install from
+		GalaxyLock             string // Lockfile recording the installed roles and collections
+		GalaxyLockMode         string // Either update or enforce the lockfile
 		Check                  bool
 		Diff                   bool
 		FlushCache             bool
@@ -60,10 +80,10 @@ type (
 		Forks                  int
 		VaultID                string
 		VaultPassword          string
-		VaultPasswordFile      string
+		VaultIDs               map[string]string // Vault passwords keyed by vault-id label
 		Verbose                int
+		DryRun                 bool
 		PrivateKey             string
-		PrivateKeyFile         string
 		User                   string
 		Connection             string
 		Timeout                int
@@ -95,15 +115,80 @@ type (
 		Content                string // Content for vault operation
 		Input                  string // Input file for vault operation
 		NewVaultCredentialsKey string // New vault credentials ID for rekeying
+		NewVaultID             string // Vault-id label to move files to when rekeying
 		Output                 string // Output file for vault operation
+
+		Retries    int // Number of times to rerun the playbook against failed hosts
+		RetryDelay int // Seconds to wait before rerunning failed hosts
+
+		GracePeriod int // Seconds to wait for ansible to exit after forwarding a signal
+
+		ParallelInventories bool // Run the playbook against all inventories concurrently
+		ParallelLimit       int  // Maximum number of inventories to run concurrently
+
+		SecretExtraVars []string // Extra vars whose values are masked in the output
+
+		ResultsFile string // JSON file to write the playbook results to
+		ReportJUnit string // JUnit XML file to write the playbook results to
+		CardPath    string // Drone card file to write the deployment summary to
+		OutputsFile string // Dotenv file to append the step outputs to
+
+		// Lint Parameters
+		LintSarif       string // SARIF file to write the lint findings to
+		LintCodeClimate string // Code Climate JSON file to write the lint findings to
+		LintFailOn      string // Minimum severity of findings which fails the step
+
+		// Inventory Parameters
+		InventoryAction string // Action for ansible-inventory (list, graph or host)
+		InventoryHost   string // Host to show the variables of for the host action
+		InventoryOutput string // File to write the resolved inventory to
+
+		// Drift Parameters
+		DriftReport    string   // JSON file to write the drift report to
+		DriftThreshold int      // Number of drifted tasks tolerated, negative to ignore the count
+		DriftTags      []string // Tags of tasks which must never drift
 	}
 
 	Plugin struct {
-		Config Config
+		Config  Config
+		Results []*RunResult
+
+		virtualenv string
+
+		stdout io.Writer
+		stderr io.Writer
+
+		ctx    context.Context
+		signal os.Signal
+
+		tempMu      sync.Mutex
+		tempFiles   []string
+		shownFiles  map[string]bool
+		dryRunFiles int
 	}
 )
 
 func (p *Plugin) Exec() error {
+	// the extra vars are needed to mask their secrets
+	if err := p.loadExtraVars(); err != nil {
+		return err
+	}
+
+	stdout := newMaskWriter(os.Stdout, p.secrets())
+	stderr := newMaskWriter(os.Stderr, p.secrets())
+
+	defer stdout.Flush()
+	defer stderr.Flush()
+
+	p.stdout = stdout
+	p.stderr = stderr
+
+	stop := p.watchSignals()
+	defer stop()
+
+	// registered temporary files are removed even after a cancellation
+	defer p.cleanup()
+
 	switch p.Config.Mode {
 	case ModePlaybook:
 		return p.executePlaybook()
@@ -111,72 +196,122 @@ func (p *Plugin) Exec() error {
 		return p.executeAdhoc()
 	case ModeVault:
 		return p.executeVault()
+	case ModeLint:
+		return p.executeLint()
+	case ModeInventory:
+		return p.executeInventory()
+	case ModeDrift:
+		return p.executeDrift()
 	default:
-		return errors.New("invalid mode: specify 'playbook' or 'adhoc'")
+		return errors.New("invalid mode: specify 'playbook', 'adhoc', 'vault', 'lint', 'inventory' or 'drift'")
 	}
 }
 
 func (p *Plugin) executePlaybook() error {
-	if err := p.playbooks(); err != nil {
+	if err := p.preparePlaybook(); err != nil {
 		return err
 	}
 
-	if err := p.ansibleConfig(); err != nil {
-		return err
+	var runErr error
+	start := time.Now()
+
+	if p.Config.ParallelInventories && len(p.Config.Inventories) > 1 {
+		runErr = p.parallelPlaybooks()
+	} else {
+		for _, inventory := range p.Config.Inventories {
+			result, err := p.retryPlaybook(inventory, p.stdout, p.stderr)
+			p.Results = append(p.Results, result)
+
+			if err != nil {
+				runErr = err
+				break
+			}
+		}
 	}
 
-	if p.Config.PrivateKey != "" {
-		if err := p.privateKey(); err != nil {
+	// nothing ran, so there are no results to report
+	if p.Config.DryRun {
+		return runErr
+	}
+
+	printSummary(p.stdout, p.Results)
+
+	if p.Config.ResultsFile != "" {
+		if err := writeResults(p.Config.ResultsFile, p.Results); err != nil {
 			return err
 		}
+	}
 
-		defer os.Remove(p.Config.PrivateKeyFile)
+	if p.Config.ReportJUnit != "" {
+		if err := writeJUnit(p.Config.ReportJUnit, p.Results); err != nil {
+			return err
+		}
 	}
 
-	if p.Config.VaultPassword != "" {
-		if err := p.vaultPass(); err != nil {
+	if p.Config.CardPath != "" {
+		if err := p.writeCard(time.Since(start), runErr); err != nil {
 			return err
 		}
+	}
 
-		defer os.Remove(p.Config.VaultPasswordFile)
+	if p.Config.OutputsFile != "" {
+		if err := p.writeOutputs(time.Since(start), runErr); err != nil {
+			return err
+		}
 	}
 
-	// Handle inline inventory content
-	if err := p.setupInventory(); err != nil {
+	return runErr
+}
+
+// preparePlaybook writes the temporary files required by ansible-playbook
+// and installs the requirements before the playbooks are run.
+func (p *Plugin) preparePlaybook() error {
+	if err := p.playbooks(); err != nil {
 		return err
 	}
 
+	if err := p.ansibleConfig(); err != nil {
+		return err
+	}
+
+	// Handle inline inventory content
+	p.setupInventory()
+
 	// Validate custom Ansible installation
 	if err := p.validateInstallation(); err != nil {
 		return err
 	}
 
-	commands := []*exec.Cmd{
-		p.versionCommand(),
+	if err := p.prepareVirtualenv(); err != nil {
+		return err
 	}
 
-	if p.Config.Requirements != "" {
-		commands = append(commands, p.requirementsCommand())
+	commands := []Command{
+		p.Config.versionCommand(),
 	}
 
-	if p.Config.Galaxy != "" {
-		commands = append(commands, p.galaxyCommand())
+	if p.Config.Requirements != "" && !p.Config.Virtualenv {
+		commands = append(commands, p.Config.requirementsCommand())
 	}
 
-	for _, inventory := range p.Config.Inventories {
-		commands = append(commands, p.ansibleCommand(inventory))
-	}
+	for _, plan := range commands {
+		cmd, err := p.command(plan)
+		if err != nil {
+			return err
+		}
 
-	for _, cmd := range commands {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		cmd.Stdout = p.stdout
+		cmd.Stderr = p.stderr
 
-		cmd.Env = os.Environ()
-		cmd.Env = append(cmd.Env, "ANSIBLE_FORCE_COLOR=1")
+		trace(p.stdout, cmd)
 
-		trace(cmd)
+		if err := p.runCommand(cmd); err != nil {
+			return err
+		}
+	}
 
-		if err := cmd.Run(); err != nil {
+	if p.Config.Galaxy != "" {
+		if err := p.installGalaxy(); err != nil {
 			return err
 		}
 	}
@@ -184,125 +319,189 @@ func (p *Plugin) executePlaybook() error {
 	return nil
 }
 
-// executeAdhoc executes the Ansible Ad-Hoc command
-func (p *Plugin) executeAdhoc() error {
-	// Step 1: Validate required parameters
-	if p.Config.Hosts == "" {
-		return errors.New("hosts parameter is required for ad-hoc execution")
-	}
+// parallelPlaybooks runs the playbook against all inventories concurrently,
+// up to the configured limit. Every output line is prefixed with the
+// inventory and all failures are collected instead of stopping early.
+func (p *Plugin) parallelPlaybooks() error {
+	var (
+		wg      sync.WaitGroup
+		stdout  = &syncWriter{w: p.stdout}
+		stderr  = &syncWriter{w: p.stderr}
+		results = make([]*RunResult, len(p.Config.Inventories))
+		errs    = make([]error, len(p.Config.Inventories))
+	)
 
-	// Step 2: Default to 'command' module if no module is provided
-	module := p.Config.Module
-	if module == "" {
-		module = "command"
+	limit := p.Config.ParallelLimit
+	if limit <= 0 || limit > len(p.Config.Inventories) {
+		limit = len(p.Config.Inventories)
 	}
 
-	// Step 3: Build arguments for the ad-hoc command
-	args := []string{
-		p.Config.Hosts, // Target hosts
-		"-m", module,   // Module to execute
-	}
+	sem := make(chan struct{}, limit)
 
-	// Step 4: Add module arguments
-	if p.Config.ModuleArguments != "" {
-		args = append(args, "-a", p.Config.ModuleArguments)
-	}
+	for i, inventory := range p.Config.Inventories {
+		wg.Add(1)
 
-	// Step 5: Add inventory files or inline content
-	if len(p.Config.Inventories) > 0 {
-		for _, inventory := range p.Config.Inventories {
-			args = append(args, "--inventory", inventory)
-		}
+		go func(i int, inventory string) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			out := newPrefixWriter(stdout, inventory)
+			errOut := newPrefixWriter(stderr, inventory)
+
+			results[i], errs[i] = p.retryPlaybook(inventory, out, errOut)
+
+			out.Flush()
+			errOut.Flush()
+		}(i, inventory)
 	}
 
-	if p.Config.InventoryContent != "" {
-		tmpfile, err := os.CreateTemp("", "inventory")
+	wg.Wait()
+
+	p.Results = append(p.Results, results...)
+
+	var failed []string
+
+	for i, err := range errs {
 		if err != nil {
-			return fmt.Errorf("failed to create temporary inventory file: %w", err)
-		}
-		defer os.Remove(tmpfile.Name())
-		if _, err := tmpfile.WriteString(p.Config.InventoryContent); err != nil {
-			return fmt.Errorf("failed to write inventory content to temporary file: %w", err)
+			failed = append(failed, fmt.Sprintf("%s (%s)", p.Config.Inventories[i], err))
 		}
-		args = append(args, "--inventory", tmpfile.Name())
 	}
 
-	// Step 6: Handle privilege escalation
-	if p.Config.Become {
-		args = append(args, "--become")
-	}
-	if p.Config.BecomeUser != "" {
-		args = append(args, "--become-user", p.Config.BecomeUser)
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d inventories failed: %s", len(failed), len(errs), strings.Join(failed, ", "))
 	}
 
-	// Step 7: Handle dynamic inventory
-	if p.Config.DynamicInventory {
-		args = append(args, "--dynamic-inventory")
+	return nil
+}
+
+// retryPlaybook runs the playbook and reruns it against the failed and
+// unreachable hosts of the previous attempt until they succeed or the
+// configured retries are exhausted.
+func (p *Plugin) retryPlaybook(inventory string, stdout, stderr io.Writer) (*RunResult, error) {
+	result, err := p.runPlaybook(inventory, nil, stdout, stderr)
+
+	for attempt := 1; err != nil && attempt <= p.Config.Retries; attempt++ {
+		hosts := result.FailedHosts()
+
+		if len(hosts) == 0 {
+			break
+		}
+
+		fmt.Fprintf(
+			stdout,
+			"Retrying %d failed hosts of %s in %ds (attempt %d of %d): %s\n",
+			len(hosts),
+			inventory,
+			p.Config.RetryDelay,
+			attempt,
+			p.Config.Retries,
+			strings.Join(hosts, ", "),
+		)
+
+		if err := p.sleep(time.Duration(p.Config.RetryDelay) * time.Second); err != nil {
+			return result, err
+		}
+
+		retry, retryErr := p.runPlaybook(inventory, hosts, stdout, stderr)
+
+		result.Merge(retry)
+		err = retryErr
 	}
 
-	// Step 8: Add extra variables
-	for _, ev := range p.Config.ExtraVars {
-		args = append(args, "--extra-vars", ev)
+	return result, err
+}
+
+// runPlaybook executes ansible-playbook against a single inventory, limited
+// to the retry hosts if any, and records the parsed PLAY RECAP.
+func (p *Plugin) runPlaybook(inventory string, retry []string, stdout, stderr io.Writer) (*RunResult, error) {
+	result := &RunResult{
+		Inventory: inventory,
+		Playbooks: p.Config.Playbooks,
+		Attempts:  1,
 	}
 
-	// Step 9: Add additional options
-	if p.Config.Extras != "" {
-		args = append(args, p.Config.Extras)
+	parser := newResultParser(result)
+
+	cmd, err := p.command(p.Config.playbookCommand(inventory, retry))
+	if err != nil {
+		return result, err
 	}
 
-	// Step 10: Handle forks for parallelism
-	if p.Config.Forks > 0 {
-		args = append(args, "--forks", strconv.Itoa(p.Config.Forks))
+	cmd.Stdout = io.MultiWriter(stdout, parser)
+	cmd.Stderr = stderr
+
+	trace(stdout, cmd)
+
+	start := time.Now()
+	err = p.runCommand(cmd)
+
+	parser.Flush()
+	result.Duration = time.Since(start)
+
+	if err != nil {
+		result.Error = err.Error()
 	}
 
-	// Step 11: Handle host key checking
-	env := os.Environ()
-	if !p.Config.HostKeyChecking {
-		env = append(env, "ANSIBLE_HOST_KEY_CHECKING=False")
-	} else {
-		env = append(env, "ANSIBLE_HOST_KEY_CHECKING=True")
+	return result, err
+}
+
+// executeAdhoc executes the Ansible Ad-Hoc command
+func (p *Plugin) executeAdhoc() error {
+	// Step 1: Validate required parameters
+	if p.Config.Hosts == "" {
+		return errors.New("hosts parameter is required for ad-hoc execution")
 	}
 
-	// Step 12: Handle vault credentials key
-	if p.Config.VaultCredentialsKey != "" {
-		tmpVaultFile, err := os.CreateTemp("", "vault-pass")
-		if err != nil {
-			return fmt.Errorf("failed to create temporary vault password file: %w", err)
-		}
-		defer os.Remove(tmpVaultFile.Name())
-		if _, err := tmpVaultFile.WriteString(p.Config.VaultCredentialsKey); err != nil {
-			return fmt.Errorf("failed to write vault password to temporary file: %w", err)
-		}
-		args = append(args, "--vault-password-file", tmpVaultFile.Name())
+	// Step 2: Handle the ansible config
+	if err := p.ansibleConfig(); err != nil {
+		return err
 	}
 
-	// Step 13: Handle vault temporary path
-	if p.Config.VaultTmpPath != "" {
-		args = append(args, "--vault-password-file", p.Config.VaultTmpPath)
+	if err := p.prepareVirtualenv(); err != nil {
+		return err
 	}
 
-	// Step 14: Handle private key file
-	if p.Config.PrivateKeyFile != "" {
-		args = append(args, "--private-key", p.Config.PrivateKeyFile)
+	// Step 3: Construct the command
+	cmd, err := p.command(p.Config.adhocCommand())
+	if err != nil {
+		return err
 	}
 
-	// Step 15: Use custom Ansible installation if provided
-	executable := "ansible"
-	if p.Config.Installation != "" {
-		executable = p.Config.Installation
+	result := &RunResult{
+		Inventory: strings.Join(p.Config.Inventories, ","),
+		Attempts:  1,
 	}
 
-	// Step 16: Construct and execute the command
-	cmd := exec.Command(executable, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Env = env // Pass environment variables
+	parser := newResultParser(result)
+
+	cmd.Stdout = io.MultiWriter(p.stdout, parser)
+	cmd.Stderr = p.stderr
 
 	// Log the command for debugging purposes
-	fmt.Printf("Executing command: %s %v\n", executable, args)
+	fmt.Fprintf(p.stdout, "Executing command: %s %v\n", cmd.Args[0], cmd.Args[1:])
+
+	// Step 4: Run the command and record the per-host outcome
+	start := time.Now()
+	runErr := p.runCommand(cmd)
+
+	parser.Flush()
+	result.Duration = time.Since(start)
+
+	if runErr != nil {
+		result.Error = runErr.Error()
+	}
+
+	p.Results = append(p.Results, result)
+
+	if p.Config.OutputsFile != "" && !p.Config.DryRun {
+		if err := p.writeOutputs(result.Duration, runErr); err != nil {
+			return err
+		}
+	}
 
-	// Step 17: Run the command
-	return cmd.Run()
+	return runErr
 }
 
 // executeVault executes the Ansible Vault operation
@@ -312,71 +511,71 @@ func (p *Plugin) executeVault() error {
 		return err
 	}
 
-	// Step 2: Determine the ansible-vault executable path
-	vaultExecutable := "ansible-vault"
-	if p.Config.Installation != "" {
-		vaultExecutable = p.Config.Installation
+	// Step 2: Use the built-in vault implementation without a custom installation,
+	// verify is only implemented natively
+	if p.Config.Action == ActionVerify || p.Config.Installation == "" && nativeVaultActions[p.Config.Action] {
+		return p.executeNativeVault()
 	}
 
-	// Step 3: Build arguments for the ansible-vault command
-	args := []string{p.Config.Action}
-
-	// Step 4: Handle input or content based on the action
+	// Step 3: Validate the content or the input files based on the action
+	var files []string
 	if p.Config.Action == ActionEncryptString {
 		if p.Config.Content == "" {
 			return errors.New("content is required for encrypt_string action")
 		}
-		args = append(args, "--output", p.Config.Output)
 	} else {
-		if p.Config.Input == "" {
-			return fmt.Errorf("input file is required for %s action", p.Config.Action)
+		var err error
+		if files, err = p.vaultInputs(); err != nil {
+			return err
 		}
-		args = append(args, p.Config.Input)
 	}
 
-	// Step 5: Add output file (if applicable)
-	handleOutputFile(p.Config.Output, &args)
+	// Step 4: Validate the vault passwords
+	if p.Config.VaultCredentialsKey == "" && len(p.Config.VaultIDs) == 0 {
+		return errors.New("vaultCredentialsKey is required for vault operations")
+	}
 
-	// Step 6: Handle vault password key
-	vaultPasswordFile, err := handleVaultPassword(p.Config.VaultCredentialsKey, p.Config.VaultTmpPath, &args)
-	if err != nil {
-		return err
+	if _, ok := p.Config.VaultIDs[p.Config.NewVaultID]; p.Config.Action == ActionRekey && p.Config.NewVaultID != "" && !ok {
+		return fmt.Errorf("new vault id %s is not part of vault_ids", p.Config.NewVaultID)
 	}
-	defer os.Remove(vaultPasswordFile) // Clean up the temporary password file after execution
 
-	// Step 7: Handle new vault password key for rekeying
-	if p.Config.Action == ActionRekey && p.Config.NewVaultCredentialsKey != "" {
-		newVaultPasswordFile, err := handleNewVaultPassword(p.Config.NewVaultCredentialsKey, p.Config.VaultTmpPath, &args)
-		if err != nil {
-			return err
-		}
-		defer os.Remove(newVaultPasswordFile) // Cleanup after execution
+	if err := p.ansibleConfig(); err != nil {
+		return err
 	}
 
-	// Step 8: Construct the command
-	cmd := exec.Command(vaultExecutable, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	if err := p.prepareVirtualenv(); err != nil {
+		return err
+	}
 
-	// Step 9: Pass the string content via stdin if encrypt_string
+	// Step 5: Pass the string content via stdin if encrypt_string
 	if p.Config.Action == ActionEncryptString {
-		stdin, err := cmd.StdinPipe()
-		if err != nil {
-			return fmt.Errorf("failed to open stdin pipe: %w", err)
-		}
-		go func() {
-			defer stdin.Close()
-			if _, writeErr := stdin.Write([]byte(p.Config.Content)); writeErr != nil {
-				fmt.Fprintf(os.Stderr, "failed to write to stdin: %v\n", writeErr)
-			}
-		}()
+		return p.runVaultCommand(p.Config.vaultCommand(""), p.Config.Content)
 	}
 
-	// Step 10: Log the command for debugging purposes
-	fmt.Printf("Executing command: %s %v\n", vaultExecutable, args)
+	// Step 6: Run the action for every input file, rolling back on failure
+	return p.vaultBatch(p.Config.Action, files, func(file string) error {
+		return p.runVaultCommand(p.Config.vaultCommand(file), "")
+	})
+}
 
-	// Step 11: Execute the command
-	if err := cmd.Run(); err != nil {
+// runVaultCommand executes ansible-vault, optionally passing content via stdin
+func (p *Plugin) runVaultCommand(plan Command, content string) error {
+	cmd, err := p.command(plan)
+	if err != nil {
+		return err
+	}
+
+	cmd.Stdout = p.stdout
+	cmd.Stderr = p.stderr
+
+	if content != "" {
+		cmd.Stdin = strings.NewReader(content)
+	}
+
+	// Log the command for debugging purposes
+	fmt.Fprintf(p.stdout, "Executing command: %s %v\n", cmd.Args[0], cmd.Args[1:])
+
+	if err := p.runCommand(cmd); err != nil {
 		return fmt.Errorf("ansible-vault command failed: %w", err)
 	}
 
@@ -392,87 +591,14 @@ func validateAction(action string) error {
 		ActionView:          true,
 		ActionEdit:          true,
 		ActionRekey:         true,
+		ActionVerify:        true,
 	}
 	if !validActions[action] {
-		return fmt.Errorf("invalid action: %s. Supported actions: encrypt, decrypt, encrypt_string, view, edit, rekey", action)
+		return fmt.Errorf("invalid action: %s. Supported actions: encrypt, decrypt, encrypt_string, view, edit, rekey, verify", action)
 	}
 	return nil
 }
 
-// handleOutputFile adds the output file flag if provided
-func handleOutputFile(output string, args *[]string) {
-	if output != "" {
-		*args = append(*args, "--output", output)
-	}
-}
-
-// handleVaultPassword writes the vault password to a temporary file and appends it to args
-func handleVaultPassword(vaultKey string, vaultTmpPath string, args *[]string) (string, error) {
-	if vaultKey == "" {
-		return "", errors.New("vaultCredentialsKey is required for vault operations")
-	}
-
-	// Create a temporary Vault password file
-	tmpVaultFile, err := createVaultTmpFile(vaultTmpPath, "vault-pass")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temporary vault password file: %w", err)
-	}
-
-	// Write the password to the file
-	if _, err := tmpVaultFile.WriteString(vaultKey); err != nil {
-		tmpVaultFile.Close()
-		return "", fmt.Errorf("failed to write vault key to temporary file: %w", err)
-	}
-
-	// Close the file to ensure the data is flushed
-	if err := tmpVaultFile.Close(); err != nil {
-		return "", fmt.Errorf("failed to close vault password file: %w", err)
-	}
-
-	// Set correct permissions
-	if err := os.Chmod(tmpVaultFile.Name(), 0600); err != nil {
-		return "", fmt.Errorf("failed to set permissions on vault password file: %w", err)
-	}
-
-	// Append the file path to the args
-	*args = append(*args, "--vault-password-file", tmpVaultFile.Name())
-	return tmpVaultFile.Name(), nil
-}
-
-func handleNewVaultPassword(newVaultKey string, vaultTmpPath string, args *[]string) (string, error) {
-	// Create a temporary file for the new vault password
-	tmpNewVaultFile, err := createVaultTmpFile(vaultTmpPath, "new-vault-pass")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temporary new vault password file: %w", err)
-	}
-
-	// Write the new password to the file
-	if _, err := tmpNewVaultFile.WriteString(newVaultKey); err != nil {
-		tmpNewVaultFile.Close()
-		return "", fmt.Errorf("failed to write new vault key to temporary file: %w", err)
-	}
-
-	// Close the file to ensure the data is flushed
-	if err := tmpNewVaultFile.Close(); err != nil {
-		return "", fmt.Errorf("failed to close new vault password file: %w", err)
-	}
-
-	// Append the new vault password file to the args
-	*args = append(*args, "--new-vault-password-file", tmpNewVaultFile.Name())
-	return tmpNewVaultFile.Name(), nil
-}
-
-// createVaultTmpFile creates a temporary file in the specified VaultTmpPath or system default
-func createVaultTmpFile(vaultTmpPath, prefix string) (*os.File, error) {
-	if vaultTmpPath != "" {
-		if err := ensureDirectoryExists(vaultTmpPath); err != nil {
-			return nil, err
-		}
-		return os.CreateTemp(vaultTmpPath, prefix)
-	}
-	return os.CreateTemp("", prefix)
-}
-
 // ensureDirectoryExists ensures the directory exists or creates it
 func ensureDirectoryExists(dir string) error {
 	info, err := os.Stat(dir)
@@ -488,75 +614,11 @@ func ensureDirectoryExists(dir string) error {
 	return nil
 }
 
-func (p *Plugin) ansibleConfig() error {
-	if err := os.MkdirAll(ansibleFolder, os.ModePerm); err != nil {
-		return errors.Wrap(err, "failed to create ansible directory")
-	}
-
-	ansibleConfigContent := "[defaults]\n"
-	if p.Config.DisableHostKeyChecking {
-		ansibleConfigContent += "host_key_checking = False\n"
-	}
-
-	if err := os.WriteFile(ansibleConfig, []byte(ansibleConfigContent), 0600); err != nil {
-		return errors.Wrap(err, "failed to create ansible config")
-	}
-
-	return nil
-}
-
-func (p *Plugin) privateKey() error {
-	tmpfile, err := os.CreateTemp("", "privateKey")
-
-	if err != nil {
-		return errors.Wrap(err, "failed to create private key file")
-	}
-
-	if _, err := tmpfile.Write([]byte(p.Config.PrivateKey)); err != nil {
-		return errors.Wrap(err, "failed to write private key file")
-	}
-
-	if err := tmpfile.Close(); err != nil {
-		return errors.Wrap(err, "failed to close private key file")
-	}
-
-	p.Config.PrivateKeyFile = tmpfile.Name()
-	return nil
-}
-
-func (p *Plugin) vaultPass() error {
-	tmpfile, err := os.CreateTemp("", "vaultPass")
-
-	if err != nil {
-		return errors.Wrap(err, "failed to create vault password file")
-	}
-
-	if _, err := tmpfile.Write([]byte(p.Config.VaultPassword)); err != nil {
-		return errors.Wrap(err, "failed to write vault password file")
-	}
-
-	if err := tmpfile.Close(); err != nil {
-		return errors.Wrap(err, "failed to close vault password file")
-	}
-
-	p.Config.VaultPasswordFile = tmpfile.Name()
-	return nil
-}
-
-// setupInventory handles inline inventory content
-func (p *Plugin) setupInventory() error {
+// setupInventory adds the inline inventory content to the inventories
+func (p *Plugin) setupInventory() {
 	if p.Config.InventoryContent != "" {
-		tmpfile, err := os.CreateTemp("", "inventory")
-		if err != nil {
-			return errors.Wrap(err, "failed to create temporary inventory file")
-		}
-		if _, err := tmpfile.WriteString(p.Config.InventoryContent); err != nil {
-			return errors.Wrap(err, "failed to write inventory content")
-		}
-		defer tmpfile.Close()
-		p.Config.Inventories = append(p.Config.Inventories, tmpfile.Name())
+		p.Config.Inventories = append(p.Config.Inventories, InlineInventory)
 	}
-	return nil
 }
 
 // validateInstallation checks if the specified Ansible installation exists
@@ -570,20 +632,7 @@ func (p *Plugin) validateInstallation() error {
 }
 
 func (p *Plugin) playbooks() error {
-	var (
-		playbooks []string
-	)
-
-	for _, p := range p.Config.Playbooks {
-		files, err := filepath.Glob(p)
-
-		if err != nil {
-			playbooks = append(playbooks, p)
-			continue
-		}
-
-		playbooks = append(playbooks, files...)
-	}
+	playbooks := expandGlobs(p.Config.Playbooks)
 
 	if len(playbooks) == 0 {
 		return errors.New("failed to find playbook files")
@@ -593,211 +642,39 @@ func (p *Plugin) playbooks() error {
 	return nil
 }
 
-func (p *Plugin) versionCommand() *exec.Cmd {
-	args := []string{
-		"--version",
-	}
-
-	return exec.Command(
-		"ansible",
-		args...,
-	)
-}
-
-func (p *Plugin) requirementsCommand() *exec.Cmd {
-	args := []string{
-		"install",
-		"--upgrade",
-		"--requirement",
-		p.Config.Requirements,
-	}
-
-	return exec.Command(
-		"pip",
-		args...,
+// expandGlobs resolves the patterns to the matching files, patterns which
+// are no valid glob are kept as they are.
+func expandGlobs(patterns []string) []string {
+	var (
+		files []string
 	)
-}
 
-func (p *Plugin) galaxyCommand() *exec.Cmd {
-	args := []string{
-		"install",
-	}
-
-	if p.Config.GalaxyForce {
-		args = append(args, "--force")
-	}
+	for _, p := range patterns {
+		matches, err := filepath.Glob(p)
 
-	args = append(args,
-		"--role-file",
-		p.Config.Galaxy,
-	)
+		if err != nil {
+			files = append(files, p)
+			continue
+		}
 
-	if p.Config.Verbose > 0 {
-		args = append(args, fmt.Sprintf("-%s", strings.Repeat("v", p.Config.Verbose)))
+		files = append(files, matches...)
 	}
 
-	return exec.Command(
-		"ansible-galaxy",
-		args...,
-	)
+	return files
 }
 
-func (p *Plugin) ansibleCommand(inventory string) *exec.Cmd {
-	args := []string{
-		"--inventory",
-		inventory,
-	}
-
-	if len(p.Config.ModulePath) > 0 {
-		args = append(args, "--module-path", strings.Join(p.Config.ModulePath, ":"))
-	}
-
-	if p.Config.VaultID != "" {
-		args = append(args, "--vault-id", p.Config.VaultID)
-	}
-
-	if p.Config.VaultPasswordFile != "" {
-		args = append(args, "--vault-password-file", p.Config.VaultPasswordFile)
-	}
-
-	if p.Config.VaultTmpPath != "" {
-		args = append(args, "--vault-password-file", p.Config.VaultTmpPath) // Vault temporary path
-	}
-
-	for _, v := range p.Config.ExtraVars {
-		args = append(args, "--extra-vars", v)
-	}
-
-	if p.Config.ListHosts {
-		args = append(args, "--list-hosts")
-		args = append(args, p.Config.Playbooks...)
-
-		return exec.Command(
-			"ansible-playbook",
-			args...,
-		)
-	}
-
-	if p.Config.SyntaxCheck {
-		args = append(args, "--syntax-check")
-		args = append(args, p.Config.Playbooks...)
-
-		return exec.Command(
-			"ansible-playbook",
-			args...,
-		)
-	}
-
-	if p.Config.Check {
-		args = append(args, "--check")
-	}
-
-	if p.Config.Diff {
-		args = append(args, "--diff")
-	}
-
-	if p.Config.FlushCache {
-		args = append(args, "--flush-cache")
-	}
+// sortedKeys returns the keys of the map in a stable order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
 
-	if p.Config.ForceHandlers {
-		args = append(args, "--force-handlers")
+	for key := range m {
+		keys = append(keys, key)
 	}
 
-	if p.Config.Forks != 5 {
-		args = append(args, "--forks", strconv.Itoa(p.Config.Forks))
-	}
-
-	if p.Config.Limit != "" {
-		args = append(args, "--limit", p.Config.Limit)
-	}
-
-	if p.Config.ListTags {
-		args = append(args, "--list-tags")
-	}
-
-	if p.Config.ListTasks {
-		args = append(args, "--list-tasks")
-	}
-
-	if p.Config.SkipTags != "" {
-		args = append(args, "--skip-tags", p.Config.SkipTags)
-	}
-
-	if p.Config.StartAtTask != "" {
-		args = append(args, "--start-at-task", p.Config.StartAtTask)
-	}
-
-	if p.Config.Tags != "" {
-		args = append(args, "--tags", p.Config.Tags)
-	}
-
-	if p.Config.PrivateKeyFile != "" {
-		args = append(args, "--private-key", p.Config.PrivateKeyFile)
-	}
-
-	if p.Config.User != "" {
-		args = append(args, "--user", p.Config.User)
-	}
-
-	if p.Config.Connection != "" {
-		args = append(args, "--connection", p.Config.Connection)
-	}
-
-	if p.Config.Timeout != 0 {
-		args = append(args, "--timeout", strconv.Itoa(p.Config.Timeout))
-	}
-
-	if p.Config.SSHCommonArgs != "" {
-		args = append(args, "--ssh-common-args", p.Config.SSHCommonArgs)
-	}
-
-	if p.Config.SFTPExtraArgs != "" {
-		args = append(args, "--sftp-extra-args", p.Config.SFTPExtraArgs)
-	}
-
-	if p.Config.SCPExtraArgs != "" {
-		args = append(args, "--scp-extra-args", p.Config.SCPExtraArgs)
-	}
-
-	if p.Config.SSHExtraArgs != "" {
-		args = append(args, "--ssh-extra-args", p.Config.SSHExtraArgs)
-	}
-
-	if p.Config.Become {
-		args = append(args, "--become")
-	}
-
-	if p.Config.BecomeMethod != "" {
-		args = append(args, "--become-method", p.Config.BecomeMethod)
-	}
-
-	if p.Config.BecomeUser != "" {
-		args = append(args, "--become-user", p.Config.BecomeUser)
-	}
-
-	if p.Config.Verbose > 0 {
-		args = append(args, fmt.Sprintf("-%s", strings.Repeat("v", p.Config.Verbose)))
-	}
-
-	args = append(args, p.Config.Playbooks...)
-
-	return exec.Command(p.ansibleExecutable(), args...)
-
-	// return exec.Command(
-	// 	"ansible-playbook",
-	// 	args...,
-	// )
-}
-
-// ansibleExecutable determines the executable to use
-func (p *Plugin) ansibleExecutable() string {
-	if p.Config.Installation != "" {
-		return p.Config.Installation
-	}
-	return "ansible-playbook"
+	sort.Strings(keys)
+	return keys
 }
 
-func trace(cmd *exec.Cmd) {
-	fmt.Println("$", strings.Join(cmd.Args, " "))
+func trace(w io.Writer, cmd *exec.Cmd) {
+	fmt.Fprintln(w, "$", strings.Join(cmd.Args, " "))
 }
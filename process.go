@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// watchSignals cancels the plugin context on SIGINT or SIGTERM, which
+// forwards the signal to the running ansible processes. The returned
+// function stops watching.
+func (p *Plugin) watchSignals() func() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case sig := <-signals:
+			fmt.Fprintf(p.stderr, "received %s, stopping ansible\n", sig)
+
+			p.signal = sig
+			cancel()
+		case <-done:
+		}
+	}()
+
+	p.ctx = ctx
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+}
+
+// runCommand runs the command in its own process group. When the plugin
+// gets cancelled the received signal is forwarded to the whole group,
+// which gets killed if it does not exit within the grace period. In dry
+// run mode the command is only printed.
+func (p *Plugin) runCommand(cmd *exec.Cmd) error {
+	if err := p.cancelled(); err != nil {
+		return err
+	}
+
+	if p.Config.DryRun {
+		p.dryRun(cmd)
+		return nil
+	}
+
+	setProcessGroup(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-p.ctx.Done():
+	}
+
+	if err := signalProcessGroup(cmd, p.signal); err != nil {
+		fmt.Fprintf(p.stderr, "failed to forward %s to %s: %s\n", p.signal, cmd.Path, err)
+	}
+
+	grace := time.Duration(p.Config.GracePeriod) * time.Second
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		fmt.Fprintf(p.stderr, "%s did not exit within %s, killing it\n", cmd.Path, grace)
+
+		killProcessGroup(cmd)
+		<-done
+	}
+
+	return p.cancelled()
+}
+
+// cancelled returns an error once the plugin received a signal.
+func (p *Plugin) cancelled() error {
+	if p.ctx.Err() != nil {
+		return fmt.Errorf("execution cancelled by %s", p.signal)
+	}
+	return nil
+}
+
+// sleep waits for the duration unless the plugin gets cancelled.
+func (p *Plugin) sleep(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-p.ctx.Done():
+		return p.cancelled()
+	}
+}
+
+// removeLater registers a temporary file which is removed once the plugin
+// finishes, even if the execution got cancelled.
+func (p *Plugin) removeLater(path string) {
+	p.tempMu.Lock()
+	defer p.tempMu.Unlock()
+
+	p.tempFiles = append(p.tempFiles, path)
+}
+
+// showLater registers a temporary file whose content is printed in dry runs.
+func (p *Plugin) showLater(path string) {
+	p.tempMu.Lock()
+	defer p.tempMu.Unlock()
+
+	if p.shownFiles == nil {
+		p.shownFiles = map[string]bool{}
+	}
+
+	p.shownFiles[path] = true
+}
+
+// cleanup removes all registered temporary files and directories.
+func (p *Plugin) cleanup() {
+	p.tempMu.Lock()
+	defer p.tempMu.Unlock()
+
+	for _, path := range p.tempFiles {
+		if err := os.RemoveAll(path); err != nil {
+			fmt.Fprintf(p.stderr, "failed to remove %s: %s\n", path, err)
+		}
+	}
+
+	p.tempFiles = nil
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// HostStats holds the PLAY RECAP counters of a single host.
+	HostStats struct {
+		Host        string `json:"host"`
+		Ok          int    `json:"ok"`
+		Changed     int    `json:"changed"`
+		Unreachable int    `json:"unreachable"`
+		Failed      int    `json:"failed"`
+		Skipped     int    `json:"skipped"`
+		Rescued     int    `json:"rescued"`
+		Ignored     int    `json:"ignored"`
+	}
+
+	// TaskResult is the outcome of a single task on a single host.
+	TaskResult struct {
+		Name     string        `json:"name"`
+		Host     string        `json:"host"`
+		Status   string        `json:"status"`
+		Message  string        `json:"message,omitempty"`
+		Duration time.Duration `json:"duration"`
+	}
+
+	// PlayResult groups the task results of a single play.
+	PlayResult struct {
+		Name  string        `json:"name"`
+		Tasks []*TaskResult `json:"tasks"`
+	}
+
+	// RunResult is the outcome of a single ansible-playbook or ansible invocation.
+	RunResult struct {
+		Inventory string        `json:"inventory"`
+		Playbooks []string      `json:"playbooks"`
+		Plays     []*PlayResult `json:"plays"`
+		Hosts     []*HostStats  `json:"hosts"`
+		Attempts  int           `json:"attempts"`
+		Duration  time.Duration `json:"duration"`
+		Error     string        `json:"error,omitempty"`
+	}
+)
+
+// Task states as printed by the default stdout callback.
+const (
+	TaskOk          = "ok"
+	TaskChanged     = "changed"
+	TaskSkipped     = "skipped"
+	TaskFailed      = "failed"
+	TaskUnreachable = "unreachable"
+	TaskIgnored     = "ignored"
+)
+
+// taskPriority decides which state wins when a looped task reports
+// several items for the same host.
+var taskPriority = map[string]int{
+	TaskSkipped:     1,
+	TaskOk:          2,
+	TaskChanged:     3,
+	TaskIgnored:     4,
+	TaskUnreachable: 5,
+	TaskFailed:      6,
+}
+
+// Failed reports whether the task failed or the host was unreachable.
+func (t *TaskResult) Failed() bool {
+	return t.Status == TaskFailed || t.Status == TaskUnreachable
+}
+
+// Success reports whether the host finished without failures.
+func (h *HostStats) Success() bool {
+	return h.Failed == 0 && h.Unreachable == 0
+}
+
+// Host returns the stats of the named host, or nil if it is unknown.
+func (r *RunResult) Host(name string) *HostStats {
+	for _, h := range r.Hosts {
+		if h.Host == name {
+			return h
+		}
+	}
+	return nil
+}
+
+// FailedHosts returns the hosts which failed or were unreachable.
+func (r *RunResult) FailedHosts() []string {
+	var (
+		hosts []string
+	)
+
+	for _, h := range r.Hosts {
+		if !h.Success() {
+			hosts = append(hosts, h.Host)
+		}
+	}
+
+	return hosts
+}
+
+// Merge folds the result of a retry into the result. The stats and task
+// results of the retried hosts are replaced by their latest outcome.
+func (r *RunResult) Merge(retry *RunResult) {
+	retried := map[string]bool{}
+
+	for _, h := range retry.Hosts {
+		retried[h.Host] = true
+
+		if stats := r.Host(h.Host); stats != nil {
+			*stats = *h
+			continue
+		}
+
+		r.Hosts = append(r.Hosts, h)
+	}
+
+	for _, play := range retry.Plays {
+		for _, t := range play.Tasks {
+			retried[t.Host] = true
+		}
+	}
+
+	// earlier task results of the retried hosts are superseded
+	populated := map[*PlayResult]bool{}
+
+	for _, play := range r.Plays {
+		populated[play] = len(play.Tasks) > 0
+
+		var tasks []*TaskResult
+		for _, t := range play.Tasks {
+			if !retried[t.Host] {
+				tasks = append(tasks, t)
+			}
+		}
+
+		play.Tasks = tasks
+	}
+
+	// plays keep their position, a retry runs them in the same order
+	merged := map[*PlayResult]bool{}
+
+	for _, play := range retry.Plays {
+		if existing := r.unmergedPlay(play.Name, merged); existing != nil {
+			existing.Tasks = append(existing.Tasks, play.Tasks...)
+			merged[existing] = true
+			continue
+		}
+
+		r.Plays = append(r.Plays, play)
+		merged[play] = true
+	}
+
+	plays := r.Plays[:0]
+	for _, play := range r.Plays {
+		if len(play.Tasks) > 0 || !populated[play] {
+			plays = append(plays, play)
+		}
+	}
+	r.Plays = plays
+
+	r.Attempts += retry.Attempts
+	r.Duration += retry.Duration
+	r.Error = retry.Error
+}
+
+// unmergedPlay returns the first play with the name not merged yet.
+func (r *RunResult) unmergedPlay(name string, merged map[*PlayResult]bool) *PlayResult {
+	for _, play := range r.Plays {
+		if play.Name == name && !merged[play] {
+			return play
+		}
+	}
+	return nil
+}
+
+// Success reports whether the run and all of its hosts succeeded.
+func (r *RunResult) Success() bool {
+	if r.Error != "" {
+		return false
+	}
+	for _, h := range r.Hosts {
+		if !h.Success() {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	ansiPattern  = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+	recapPattern = regexp.MustCompile(`^(\S+)\s*:\s*(ok=\d+.*)$`)
+	playPattern  = regexp.MustCompile(`^PLAY \[(.*)\] \*+$`)
+	taskPattern  = regexp.MustCompile(`^(?:TASK|RUNNING HANDLER) \[(.*)\] \*+$`)
+	hostPattern  = regexp.MustCompile(`^(ok|changed|skipping|fatal|failed): \[([^\]]+)\](.*)$`)
+	adhocPattern = regexp.MustCompile(`^(\S+) \| (SUCCESS|CHANGED|FAILED!?|UNREACHABLE!)(?: |$)`)
+)
+
+// resultParser is an io.Writer which consumes ansible-playbook output
+// line by line and records plays, tasks and the PLAY RECAP into a
+// RunResult. Ad-hoc output has no recap, the per-host lines are counted
+// as a single task instead.
+type resultParser struct {
+	result  *RunResult
+	buf     []byte
+	inRecap bool
+
+	play      *PlayResult
+	task      string
+	taskStart time.Time
+	last      *TaskResult
+
+	now func() time.Time
+}
+
+func newResultParser(result *RunResult) *resultParser {
+	return &resultParser{
+		result: result,
+		now:    time.Now,
+	}
+}
+
+func (r *resultParser) Write(p []byte) (int, error) {
+	r.buf = append(r.buf, p...)
+
+	for {
+		i := bytes.IndexByte(r.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		r.line(string(r.buf[:i]))
+		r.buf = r.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// Flush parses any trailing output not terminated by a newline.
+func (r *resultParser) Flush() {
+	if len(r.buf) > 0 {
+		r.line(string(r.buf))
+		r.buf = nil
+	}
+}
+
+func (r *resultParser) line(line string) {
+	line = strings.TrimRight(ansiPattern.ReplaceAllString(line, ""), "\r ")
+
+	if strings.HasPrefix(line, "PLAY RECAP") {
+		r.inRecap = true
+		return
+	}
+
+	if !r.inRecap {
+		r.event(line)
+		return
+	}
+
+	if line == "" {
+		r.inRecap = false
+		return
+	}
+
+	if m := recapPattern.FindStringSubmatch(line); m != nil {
+		r.recap(m[1], m[2])
+	}
+}
+
+func (r *resultParser) event(line string) {
+	if m := playPattern.FindStringSubmatch(line); m != nil {
+		r.play = &PlayResult{Name: m[1]}
+		r.result.Plays = append(r.result.Plays, r.play)
+		r.task = ""
+		r.last = nil
+		return
+	}
+
+	if m := taskPattern.FindStringSubmatch(line); m != nil {
+		r.task = m[1]
+		r.taskStart = r.now()
+		r.last = nil
+		return
+	}
+
+	if r.last != nil && strings.TrimSpace(line) == "...ignoring" {
+		r.last.Status = TaskIgnored
+		return
+	}
+
+	if r.play == nil {
+		if m := adhocPattern.FindStringSubmatch(line); m != nil {
+			r.adhoc(m[1], m[2])
+		}
+		return
+	}
+
+	if r.task == "" {
+		return
+	}
+
+	m := hostPattern.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+
+	state, host, rest := m[1], m[2], m[3]
+
+	// delegated tasks are printed as [host -> delegate]
+	if i := strings.Index(host, " -> "); i >= 0 {
+		host = host[:i]
+	}
+
+	var message string
+	if i := strings.Index(rest, "=> "); i >= 0 {
+		message = strings.TrimSpace(rest[i+3:])
+	}
+
+	switch state {
+	case "skipping":
+		state = TaskSkipped
+	case "fatal", "failed":
+		state = TaskFailed
+		if strings.HasPrefix(strings.TrimSpace(rest), ": UNREACHABLE!") {
+			state = TaskUnreachable
+		}
+	}
+
+	r.last = r.taskResult(host)
+	r.last.Duration = r.now().Sub(r.taskStart)
+
+	if taskPriority[state] >= taskPriority[r.last.Status] {
+		r.last.Status = state
+
+		if state == TaskFailed || state == TaskUnreachable {
+			r.last.Message = message
+		}
+	}
+}
+
+func (r *resultParser) taskResult(host string) *TaskResult {
+	// looped tasks report every item of the current task before the next
+	// task header, so only the trailing entries need to be searched
+	tasks := r.play.Tasks
+	for i := len(tasks) - 1; i >= 0; i-- {
+		if tasks[i].Name != r.task {
+			break
+		}
+		if tasks[i].Host == host {
+			return tasks[i]
+		}
+	}
+
+	t := &TaskResult{
+		Name: r.task,
+		Host: host,
+	}
+
+	r.play.Tasks = append(r.play.Tasks, t)
+	return t
+}
+
+func (r *resultParser) adhoc(host, state string) {
+	stats := r.result.Host(host)
+
+	if stats == nil {
+		stats = &HostStats{Host: host}
+		r.result.Hosts = append(r.result.Hosts, stats)
+	}
+
+	switch state {
+	case "SUCCESS":
+		stats.Ok++
+	case "CHANGED":
+		stats.Ok++
+		stats.Changed++
+	case "UNREACHABLE!":
+		stats.Unreachable++
+	default:
+		stats.Failed++
+	}
+}
+
+func (r *resultParser) recap(host, counters string) {
+	stats := r.result.Host(host)
+
+	if stats == nil {
+		stats = &HostStats{Host: host}
+		r.result.Hosts = append(r.result.Hosts, stats)
+	}
+
+	for _, field := range strings.Fields(counters) {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		value, err := strconv.Atoi(parts[1])
+		if err != nil {
+			continue
+		}
+
+		switch parts[0] {
+		case "ok":
+			stats.Ok = value
+		case "changed":
+			stats.Changed = value
+		case "unreachable":
+			stats.Unreachable = value
+		case "failed":
+			stats.Failed = value
+		case "skipped":
+			stats.Skipped = value
+		case "rescued":
+			stats.Rescued = value
+		case "ignored":
+			stats.Ignored = value
+		}
+	}
+}
+
+// printSummary writes a per-inventory, per-host table of the results.
+func printSummary(w io.Writer, results []*RunResult) {
+	if len(results) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Summary:")
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "INVENTORY\tHOST\tOK\tCHANGED\tUNREACHABLE\tFAILED\tSKIPPED\tRESCUED\tIGNORED\tSTATUS")
+
+	for _, result := range results {
+		if len(result.Hosts) == 0 {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\t-\t-\t-\t-\t%s\n", result.Inventory, status(result.Success()))
+			continue
+		}
+
+		for _, h := range result.Hosts {
+			fmt.Fprintf(
+				tw,
+				"%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\n",
+				result.Inventory,
+				h.Host,
+				h.Ok,
+				h.Changed,
+				h.Unreachable,
+				h.Failed,
+				h.Skipped,
+				h.Rescued,
+				h.Ignored,
+				status(h.Success()),
+			)
+		}
+	}
+
+	tw.Flush()
+}
+
+// writeResults stores the results as JSON for later pipeline steps.
+func writeResults(path string, results []*RunResult) error {
+	content, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode results")
+	}
+
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return errors.Wrap(err, "failed to write results file")
+	}
+
+	return nil
+}
+
+func status(success bool) string {
+	if success {
+		return "success"
+	}
+	return "failure"
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// parseOutput runs ansible output through the result parser.
+func parseOutput(inventory, output string) *RunResult {
+	result := &RunResult{
+		Inventory: inventory,
+		Attempts:  1,
+	}
+
+	parser := newResultParser(result)
+	parser.Write([]byte(output))
+	parser.Flush()
+
+	return result
+}
+
+const (
+	firstAttemptOutput = `
+PLAY [all] *********************************************************************
+
+TASK [Gathering Facts] *********************************************************
+ok: [a]
+ok: [b]
+
+TASK [deploy] ******************************************************************
+changed: [a]
+fatal: [b]: FAILED! => {"changed": false, "msg": "boom"}
+
+PLAY RECAP *********************************************************************
+a                          : ok=2    changed=1    unreachable=0    failed=0    skipped=0    rescued=0    ignored=0
+b                          : ok=1    changed=0    unreachable=0    failed=1    skipped=0    rescued=0    ignored=0
+
+`
+
+	retryOutput = `
+PLAY [all] *********************************************************************
+
+TASK [Gathering Facts] *********************************************************
+ok: [b]
+
+TASK [deploy] ******************************************************************
+changed: [b]
+
+PLAY RECAP *********************************************************************
+b                          : ok=2    changed=1    unreachable=0    failed=0    skipped=0    rescued=0    ignored=0
+
+`
+)
+
+func TestRunResultMerge(t *testing.T) {
+	result := parseOutput("hosts", firstAttemptOutput)
+	result.Merge(parseOutput("hosts", retryOutput))
+
+	if !result.Success() || len(result.FailedHosts()) > 0 {
+		t.Fatalf("expected success, failed hosts %v", result.FailedHosts())
+	}
+
+	if result.Attempts != 2 {
+		t.Errorf("got %d attempts, want 2", result.Attempts)
+	}
+
+	if len(result.Plays) != 1 {
+		t.Fatalf("got %d plays, want 1", len(result.Plays))
+	}
+
+	var tasks []string
+	for _, task := range result.Plays[0].Tasks {
+		tasks = append(tasks, task.Host+" "+task.Name+" "+task.Status)
+	}
+
+	want := []string{
+		"a Gathering Facts ok",
+		"a deploy changed",
+		"b Gathering Facts ok",
+		"b deploy changed",
+	}
+
+	if strings.Join(tasks, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got tasks\n%s\nwant\n%s", strings.Join(tasks, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRunResultMergeKeepsOtherPlays(t *testing.T) {
+	result := parseOutput("hosts", `
+PLAY [web] *********************************************************************
+
+TASK [deploy] ******************************************************************
+fatal: [b]: FAILED! => {"msg": "boom"}
+
+PLAY [db] **********************************************************************
+
+TASK [migrate] *****************************************************************
+ok: [c]
+
+PLAY RECAP *********************************************************************
+b                          : ok=0    changed=0    unreachable=0    failed=1    skipped=0    rescued=0    ignored=0
+c                          : ok=1    changed=0    unreachable=0    failed=0    skipped=0    rescued=0    ignored=0
+
+`)
+
+	result.Merge(parseOutput("hosts", `
+PLAY [web] *********************************************************************
+
+TASK [deploy] ******************************************************************
+ok: [b]
+
+PLAY [db] **********************************************************************
+skipping: no hosts matched
+
+PLAY RECAP *********************************************************************
+b                          : ok=1    changed=0    unreachable=0    failed=0    skipped=0    rescued=0    ignored=0
+
+`))
+
+	var plays []string
+	for _, play := range result.Plays {
+		for _, task := range play.Tasks {
+			plays = append(plays, play.Name+" "+task.Host+" "+task.Status)
+		}
+	}
+
+	want := "web b ok\ndb c ok"
+	if got := strings.Join(plays, "\n"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRetryReports(t *testing.T) {
+	result := parseOutput("hosts", firstAttemptOutput)
+	result.Merge(parseOutput("hosts", retryOutput))
+
+	dir := t.TempDir()
+
+	junitPath := filepath.Join(dir, "junit.xml")
+	if err := writeJUnit(junitPath, []*RunResult{result}); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(junitPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var report junitTestSuites
+	if err := xml.Unmarshal(content, &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Suites) != 1 {
+		t.Fatalf("got %d testsuites, want 1", len(report.Suites))
+	}
+
+	if suite := report.Suites[0]; suite.Failures != 0 || suite.Tests != 4 {
+		t.Errorf("got %d tests with %d failures, want 4 tests without failures", suite.Tests, suite.Failures)
+	}
+
+	cardPath := filepath.Join(dir, "card.json")
+
+	p := &Plugin{
+		Config:  Config{CardPath: cardPath},
+		Results: []*RunResult{result},
+	}
+
+	if err := p.writeCard(0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err = os.ReadFile(cardPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var input cardInput
+	if err := json.Unmarshal(content, &input); err != nil {
+		t.Fatal(err)
+	}
+
+	var card Card
+	if err := json.Unmarshal(input.Data, &card); err != nil {
+		t.Fatal(err)
+	}
+
+	if card.Status != "success" || len(card.FailedTasks) != 0 {
+		t.Errorf("got status %s with failed tasks %+v", card.Status, card.FailedTasks)
+	}
+
+	for _, h := range card.Hosts {
+		if h.Status != "success" {
+			t.Errorf("got status %s for host %s", h.Status, h.Host)
+		}
+	}
+}
+
+// describeTasks renders the task results as play|task|host|status|message.
+func describeTasks(result *RunResult) []string {
+	var tasks []string
+
+	for _, play := range result.Plays {
+		for _, task := range play.Tasks {
+			tasks = append(tasks, strings.Join([]string{play.Name, task.Name, task.Host, task.Status, task.Message}, "|"))
+		}
+	}
+
+	return tasks
+}
+
+// describeHosts renders the host stats like the PLAY RECAP.
+func describeHosts(result *RunResult) []string {
+	var hosts []string
+
+	for _, h := range result.Hosts {
+		hosts = append(hosts, fmt.Sprintf(
+			"%s ok=%d changed=%d unreachable=%d failed=%d skipped=%d rescued=%d ignored=%d",
+			h.Host, h.Ok, h.Changed, h.Unreachable, h.Failed, h.Skipped, h.Rescued, h.Ignored,
+		))
+	}
+
+	return hosts
+}
+
+func TestResultParser(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		tasks  []string
+		hosts  []string
+	}{
+		{
+			name: "recap with ansi codes",
+			output: "\x1b[0;34mPLAY [web] *********************************************************************\x1b[0m\n" +
+				"\n" +
+				"\x1b[0;34mTASK [Gathering Facts] *********************************************************\x1b[0m\n" +
+				"\x1b[0;32mok: [web1]\x1b[0m\n" +
+				"\n" +
+				"\x1b[0;34mTASK [nginx : install nginx] ***************************************************\x1b[0m\n" +
+				"\x1b[0;33mchanged: [web1]\x1b[0m\n" +
+				"\n" +
+				"\x1b[0;34mRUNNING HANDLER [nginx : restart nginx] ****************************************\x1b[0m\n" +
+				"\x1b[0;33mchanged: [web1]\x1b[0m\n" +
+				"\n" +
+				"PLAY RECAP *********************************************************************\n" +
+				"\x1b[0;33mweb1\x1b[0m                       : \x1b[0;32mok=3   \x1b[0m \x1b[0;33mchanged=2   \x1b[0m unreachable=0    failed=0    skipped=0    rescued=0    ignored=0   \n" +
+				"\n",
+			tasks: []string{
+				"web|Gathering Facts|web1|ok|",
+				"web|nginx : install nginx|web1|changed|",
+				"web|nginx : restart nginx|web1|changed|",
+			},
+			hosts: []string{
+				"web1 ok=3 changed=2 unreachable=0 failed=0 skipped=0 rescued=0 ignored=0",
+			},
+		},
+		{
+			name: "unreachable",
+			output: `PLAY [all] *********************************************************************
+
+TASK [Gathering Facts] *********************************************************
+ok: [web1]
+fatal: [db1]: UNREACHABLE! => {"changed": false, "msg": "Failed to connect to the host via ssh: ssh: connect to host db1 port 22: Connection refused", "unreachable": true}
+
+PLAY RECAP *********************************************************************
+db1                        : ok=0    changed=0    unreachable=1    failed=0    skipped=0    rescued=0    ignored=0
+web1                       : ok=1    changed=0    unreachable=0    failed=0    skipped=0    rescued=0    ignored=0
+`,
+			tasks: []string{
+				"all|Gathering Facts|web1|ok|",
+				`all|Gathering Facts|db1|unreachable|{"changed": false, "msg": "Failed to connect to the host via ssh: ssh: connect to host db1 port 22: Connection refused", "unreachable": true}`,
+			},
+			hosts: []string{
+				"db1 ok=0 changed=0 unreachable=1 failed=0 skipped=0 rescued=0 ignored=0",
+				"web1 ok=1 changed=0 unreachable=0 failed=0 skipped=0 rescued=0 ignored=0",
+			},
+		},
+		{
+			name: "ignored failure",
+			output: `PLAY [web] *********************************************************************
+
+TASK [check service] ***********************************************************
+fatal: [web1]: FAILED! => {"changed": true, "cmd": ["systemctl", "is-active", "app"], "msg": "non-zero return code", "rc": 3}
+...ignoring
+ok: [web2]
+
+TASK [next] ********************************************************************
+ok: [web1]
+ok: [web2]
+
+PLAY RECAP *********************************************************************
+web1                       : ok=2    changed=1    unreachable=0    failed=0    skipped=0    rescued=0    ignored=1
+web2                       : ok=2    changed=0    unreachable=0    failed=0    skipped=0    rescued=0    ignored=0
+`,
+			tasks: []string{
+				`web|check service|web1|ignored|{"changed": true, "cmd": ["systemctl", "is-active", "app"], "msg": "non-zero return code", "rc": 3}`,
+				"web|check service|web2|ok|",
+				"web|next|web1|ok|",
+				"web|next|web2|ok|",
+			},
+			hosts: []string{
+				"web1 ok=2 changed=1 unreachable=0 failed=0 skipped=0 rescued=0 ignored=1",
+				"web2 ok=2 changed=0 unreachable=0 failed=0 skipped=0 rescued=0 ignored=0",
+			},
+		},
+		{
+			name: "looped items",
+			output: `PLAY [web] *********************************************************************
+
+TASK [install packages] ********************************************************
+ok: [web1] => (item=git)
+changed: [web1] => (item=curl)
+skipping: [web1] => (item=vim) 
+failed: [web1] (item=nope) => {"ansible_loop_var": "item", "changed": false, "item": "nope", "msg": "No package matching 'nope' is available"}
+changed: [web2] => (item=git)
+ok: [web2] => (item=curl)
+skipping: [web2] => (item=vim) 
+ok: [web2] => (item=nope)
+
+TASK [all skipped] *************************************************************
+skipping: [web1] => (item=a) 
+skipping: [web1] => (item=b) 
+skipping: [web1]
+
+PLAY RECAP *********************************************************************
+web1                       : ok=0    changed=0    unreachable=0    failed=1    skipped=1    rescued=0    ignored=0
+web2                       : ok=1    changed=1    unreachable=0    failed=0    skipped=0    rescued=0    ignored=0
+`,
+			tasks: []string{
+				`web|install packages|web1|failed|{"ansible_loop_var": "item", "changed": false, "item": "nope", "msg": "No package matching 'nope' is available"}`,
+				"web|install packages|web2|changed|",
+				"web|all skipped|web1|skipped|",
+			},
+			hosts: []string{
+				"web1 ok=0 changed=0 unreachable=0 failed=1 skipped=1 rescued=0 ignored=0",
+				"web2 ok=1 changed=1 unreachable=0 failed=0 skipped=0 rescued=0 ignored=0",
+			},
+		},
+		{
+			name: "delegated hosts",
+			output: `PLAY [web] *********************************************************************
+
+TASK [remove from load balancer] ***********************************************
+changed: [web1 -> lb1(10.0.0.5)]
+changed: [web2 -> lb1(10.0.0.5)]
+
+TASK [notify] ******************************************************************
+ok: [web1 -> localhost]
+fatal: [web2 -> localhost]: FAILED! => {"changed": false, "msg": "webhook failed"}
+
+PLAY RECAP *********************************************************************
+web1                       : ok=2    changed=1    unreachable=0    failed=0    skipped=0    rescued=0    ignored=0
+web2                       : ok=1    changed=1    unreachable=0    failed=1    skipped=0    rescued=0    ignored=0
+`,
+			tasks: []string{
+				"web|remove from load balancer|web1|changed|",
+				"web|remove from load balancer|web2|changed|",
+				"web|notify|web1|ok|",
+				`web|notify|web2|failed|{"changed": false, "msg": "webhook failed"}`,
+			},
+			hosts: []string{
+				"web1 ok=2 changed=1 unreachable=0 failed=0 skipped=0 rescued=0 ignored=0",
+				"web2 ok=1 changed=1 unreachable=0 failed=1 skipped=0 rescued=0 ignored=0",
+			},
+		},
+		{
+			name: "rescued block with crlf",
+			output: "PLAY [db] **********************************************************************\r\n" +
+				"\r\n" +
+				"TASK [migrate] *****************************************************************\r\n" +
+				"fatal: [db1]: FAILED! => {\"changed\": false, \"msg\": \"locked\"}\r\n" +
+				"\r\n" +
+				"TASK [rollback] ****************************************************************\r\n" +
+				"changed: [db1]\r\n" +
+				"\r\n" +
+				"PLAY RECAP *********************************************************************\r\n" +
+				"db1                        : ok=1    changed=1    unreachable=0    failed=0    skipped=0    rescued=1    ignored=0   \r\n" +
+				"\r\n",
+			tasks: []string{
+				`db|migrate|db1|failed|{"changed": false, "msg": "locked"}`,
+				"db|rollback|db1|changed|",
+			},
+			hosts: []string{
+				"db1 ok=1 changed=1 unreachable=0 failed=0 skipped=0 rescued=1 ignored=0",
+			},
+		},
+		{
+			name: "adhoc",
+			output: `web1 | CHANGED | rc=0 >>
+web1.example.com
+web2 | SUCCESS => {
+    "changed": false,
+    "ping": "pong"
+}
+db1 | UNREACHABLE! => {
+    "changed": false,
+    "msg": "Failed to connect to the host via ssh: Connection timed out",
+    "unreachable": true
+}
+web3 | FAILED | rc=2 >>
+ls: cannot access '/nope': No such file or directory
+non-zero return code
+web4 | FAILED! => {
+    "changed": false,
+    "msg": "The module failed to execute correctly"
+}
+`,
+			hosts: []string{
+				"web1 ok=1 changed=1 unreachable=0 failed=0 skipped=0 rescued=0 ignored=0",
+				"web2 ok=1 changed=0 unreachable=0 failed=0 skipped=0 rescued=0 ignored=0",
+				"db1 ok=0 changed=0 unreachable=1 failed=0 skipped=0 rescued=0 ignored=0",
+				"web3 ok=0 changed=0 unreachable=0 failed=1 skipped=0 rescued=0 ignored=0",
+				"web4 ok=0 changed=0 unreachable=0 failed=1 skipped=0 rescued=0 ignored=0",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			whole := parseOutput("hosts", tt.output)
+
+			// the output arrives in arbitrary chunks
+			chunked := &RunResult{Inventory: "hosts"}
+			parser := newResultParser(chunked)
+			for i := 0; i < len(tt.output); i += 7 {
+				parser.Write([]byte(tt.output[i:min(i+7, len(tt.output))]))
+			}
+			parser.Flush()
+
+			for _, result := range []*RunResult{whole, chunked} {
+				if got, want := strings.Join(describeTasks(result), "\n"), strings.Join(tt.tasks, "\n"); got != want {
+					t.Errorf("got tasks\n%s\nwant\n%s", got, want)
+				}
+
+				if got, want := strings.Join(describeHosts(result), "\n"), strings.Join(tt.hosts, "\n"); got != want {
+					t.Errorf("got hosts\n%s\nwant\n%s", got, want)
+				}
+			}
+		})
+	}
+}
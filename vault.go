@@ -0,0 +1,572 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/drone-plugins/drone-ansible/vault"
+	"github.com/pkg/errors"
+)
+
+// nativeVaultActions lists the vault actions implemented without the
+// ansible-vault executable.
+var nativeVaultActions = map[string]bool{
+	ActionEncrypt:       true,
+	ActionDecrypt:       true,
+	ActionEncryptString: true,
+	ActionView:          true,
+	ActionRekey:         true,
+	ActionVerify:        true,
+}
+
+// executeNativeVault performs the vault action with the built-in vault
+// implementation.
+func (p *Plugin) executeNativeVault() error {
+	keys := p.vaultKeys()
+	if len(keys) == 0 {
+		return errors.New("vaultCredentialsKey or vault_ids is required for vault operations")
+	}
+
+	if p.Config.Action == ActionEncryptString {
+		if p.Config.Content == "" {
+			return errors.New("content is required for encrypt_string action")
+		}
+
+		if p.Config.DryRun {
+			p.dryRunVault(nil)
+			return nil
+		}
+
+		return p.vaultEncryptString(keys)
+	}
+
+	files, err := p.vaultInputs()
+	if err != nil {
+		return err
+	}
+
+	if p.Config.DryRun {
+		p.dryRunVault(files)
+		return nil
+	}
+
+	if p.Config.Action == ActionVerify {
+		return p.vaultVerify(files, keys)
+	}
+
+	return p.vaultBatch(p.Config.Action, files, func(file string) error {
+		return p.vaultFile(file, p.Config.Output, keys)
+	})
+}
+
+// vaultKey is a vault password together with its vault-id label.
+type vaultKey struct {
+	label    string
+	password []byte
+}
+
+// envelopeLabel returns the label written to the vault header, the
+// default identity is written without one.
+func (k vaultKey) envelopeLabel() string {
+	if k.label == "default" {
+		return ""
+	}
+	return k.label
+}
+
+// vaultKeys returns the credentials key followed by the labelled vault
+// passwords.
+func (p *Plugin) vaultKeys() []vaultKey {
+	var (
+		keys []vaultKey
+	)
+
+	if password := vaultSecret(p.Config.VaultCredentialsKey); len(password) > 0 {
+		keys = append(keys, vaultKey{
+			label:    vaultLabel(p.Config.VaultID),
+			password: password,
+		})
+	}
+
+	for _, label := range sortedKeys(p.Config.VaultIDs) {
+		keys = append(keys, vaultKey{
+			label:    label,
+			password: vaultSecret(p.Config.VaultIDs[label]),
+		})
+	}
+
+	return keys
+}
+
+// encryptKey selects the identity new vault content is encrypted with:
+// the vault_ids entry named by vault_id, the credentials key, or the only
+// configured vault id.
+func (p *Plugin) encryptKey(keys []vaultKey) (vaultKey, error) {
+	label := p.Config.VaultID
+	if i := strings.Index(label, "@"); i >= 0 {
+		label = label[:i]
+	}
+
+	if password, ok := p.Config.VaultIDs[label]; ok {
+		return vaultKey{label: label, password: vaultSecret(password)}, nil
+	}
+
+	if len(keys) == 1 || p.Config.VaultCredentialsKey != "" {
+		return keys[0], nil
+	}
+
+	return vaultKey{}, errors.New("vault_id is required to choose between multiple vault_ids")
+}
+
+// rekeyKey selects the identity files are moved to when rekeying.
+func (p *Plugin) rekeyKey(label string) (vaultKey, error) {
+	if p.Config.NewVaultID != "" {
+		password, ok := p.Config.VaultIDs[p.Config.NewVaultID]
+		if !ok {
+			return vaultKey{}, fmt.Errorf("new vault id %s is not part of vault_ids", p.Config.NewVaultID)
+		}
+
+		return vaultKey{label: p.Config.NewVaultID, password: vaultSecret(password)}, nil
+	}
+
+	if password := vaultSecret(p.Config.NewVaultCredentialsKey); len(password) > 0 {
+		return vaultKey{label: label, password: password}, nil
+	}
+
+	return vaultKey{}, errors.New("newVaultCredentialsKey or new_vault_id is required for rekey action")
+}
+
+// decryptVault tries the keys matching the envelope label first and falls
+// back to all other keys, like ansible does.
+func decryptVault(env *vault.Envelope, keys []vaultKey) ([]byte, error) {
+	ordered := make([]vaultKey, 0, len(keys))
+
+	for _, key := range keys {
+		if key.label == env.Label {
+			ordered = append(ordered, key)
+		}
+	}
+
+	for _, key := range keys {
+		if key.label != env.Label {
+			ordered = append(ordered, key)
+		}
+	}
+
+	for _, key := range ordered {
+		plaintext, err := env.Decrypt(key.password)
+		if err == nil {
+			return plaintext, nil
+		}
+
+		if !errors.Is(err, vault.ErrDecrypt) {
+			return nil, err
+		}
+	}
+
+	return nil, vault.ErrDecrypt
+}
+
+// vaultInputs expands the input globs, directories and lists into the
+// files to process.
+func (p *Plugin) vaultInputs() ([]string, error) {
+	if p.Config.Input == "" {
+		return nil, fmt.Errorf("input file is required for %s action", p.Config.Action)
+	}
+
+	var files []string
+
+	for _, input := range splitList(p.Config.Input) {
+		// a literal path is expected to exist, a typo must not shrink the
+		// set of checked files
+		if !strings.ContainsAny(input, "*?[") {
+			if _, err := os.Stat(input); err != nil {
+				return nil, fmt.Errorf("file not found: %s", input)
+			}
+
+			walked, err := walkVaultInput(input)
+			if err != nil {
+				return nil, err
+			}
+
+			files = append(files, walked...)
+			continue
+		}
+
+		matches, err := filepath.Glob(input)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid input pattern %s", input)
+		}
+
+		if len(matches) == 0 && p.Config.Action == ActionVerify {
+			return nil, fmt.Errorf("no files matched %s", input)
+		}
+
+		for _, match := range matches {
+			walked, err := walkVaultInput(match)
+			if err != nil {
+				return nil, err
+			}
+
+			files = append(files, walked...)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files matched %s", p.Config.Input)
+	}
+
+	if len(files) > 1 && p.Config.Output != "" && p.Config.Action != ActionVerify {
+		return nil, errors.New("output can only be used with a single input file")
+	}
+
+	return files, nil
+}
+
+// walkVaultInput returns the files below a directory, skipping hidden
+// directories like .git, or the path itself if it is a file.
+func walkVaultInput(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", path)
+	}
+
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var files []string
+
+	err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			if file != path && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if entry.Type().IsRegular() {
+			files = append(files, file)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to walk %s", path)
+	}
+
+	return files, nil
+}
+
+type vaultBackup struct {
+	path    string
+	content []byte
+	perm    os.FileMode
+}
+
+// vaultBatch applies the action to every file and reports the outcome per
+// file. When one file fails all files processed so far are restored, so
+// the tree is never left half encrypted or half rekeyed.
+func (p *Plugin) vaultBatch(action string, files []string, fn func(file string) error) error {
+	var (
+		backups []vaultBackup
+	)
+
+	// commands are only printed in dry run mode, nothing to roll back
+	if p.Config.DryRun {
+		for _, file := range files {
+			if err := fn(file); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, file := range files {
+		if action != ActionView {
+			backup, err := backupVaultFile(file)
+			if err != nil {
+				p.restoreVaultFiles(backups)
+				return err
+			}
+
+			backups = append(backups, backup)
+		}
+
+		if err := fn(file); err != nil {
+			fmt.Fprintf(p.stdout, "FAIL %s: %s\n", file, err)
+
+			if restored := p.restoreVaultFiles(backups); restored > 0 {
+				fmt.Fprintf(p.stdout, "Rolled back %d files\n", restored)
+			}
+
+			return errors.Wrapf(err, "%s failed for %s", action, file)
+		}
+
+		fmt.Fprintf(p.stdout, "OK   %s\n", file)
+	}
+
+	fmt.Fprintf(p.stdout, "Processed %d files with %s\n", len(files), action)
+	return nil
+}
+
+func backupVaultFile(file string) (vaultBackup, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return vaultBackup{}, errors.Wrapf(err, "failed to read %s", file)
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return vaultBackup{}, errors.Wrapf(err, "failed to read %s", file)
+	}
+
+	return vaultBackup{
+		path:    file,
+		content: content,
+		perm:    info.Mode().Perm(),
+	}, nil
+}
+
+func (p *Plugin) restoreVaultFiles(backups []vaultBackup) int {
+	var restored int
+
+	for _, backup := range backups {
+		if err := os.WriteFile(backup.path, backup.content, backup.perm); err != nil {
+			fmt.Fprintf(p.stderr, "failed to restore %s: %s\n", backup.path, err)
+			continue
+		}
+
+		restored++
+	}
+
+	return restored
+}
+
+// vaultFile applies the configured action to a single file. Without an
+// output the file is rewritten in place, like ansible-vault does.
+func (p *Plugin) vaultFile(input, output string, keys []vaultKey) error {
+	info, err := os.Stat(input)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %s", input)
+	}
+
+	content, err := os.ReadFile(input)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %s", input)
+	}
+
+	if output == "" {
+		output = input
+	}
+
+	switch p.Config.Action {
+	case ActionEncrypt:
+		if vault.IsEncrypted(content) {
+			return fmt.Errorf("%s is already encrypted", input)
+		}
+
+		key, err := p.encryptKey(keys)
+		if err != nil {
+			return err
+		}
+
+		encrypted, err := vault.Encrypt(content, key.password, key.envelopeLabel())
+		if err != nil {
+			return errors.Wrapf(err, "failed to encrypt %s", input)
+		}
+
+		if err := p.writeVaultOutput(output, encrypted, info.Mode().Perm()); err != nil {
+			return err
+		}
+	case ActionDecrypt:
+		decrypted, err := decryptVaultFile(content, keys)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decrypt %s", input)
+		}
+
+		if err := p.writeVaultOutput(output, decrypted, info.Mode().Perm()); err != nil {
+			return err
+		}
+	case ActionView:
+		decrypted, err := decryptVaultFile(content, keys)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decrypt %s", input)
+		}
+
+		p.stdout.Write(decrypted)
+	case ActionRekey:
+		env, err := vault.Parse(content)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse %s", input)
+		}
+
+		newKey, err := p.rekeyKey(env.Label)
+		if err != nil {
+			return err
+		}
+
+		decrypted, err := decryptVault(env, keys)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decrypt %s", input)
+		}
+
+		encrypted, err := vault.Encrypt(decrypted, newKey.password, newKey.envelopeLabel())
+		if err != nil {
+			return errors.Wrapf(err, "failed to encrypt %s", input)
+		}
+
+		// rekey always rewrites the input file
+		if err := os.WriteFile(input, encrypted, info.Mode().Perm()); err != nil {
+			return errors.Wrapf(err, "failed to write %s", input)
+		}
+	default:
+		return fmt.Errorf("action %s is not supported natively", p.Config.Action)
+	}
+
+	return nil
+}
+
+// vaultVerify checks that every file is a vault envelope which can be
+// decrypted with the password.
+func (p *Plugin) vaultVerify(files []string, keys []vaultKey) error {
+	var failed int
+
+	for _, file := range files {
+		if err := verifyVaultFile(file, keys); err != nil {
+			fmt.Fprintf(p.stdout, "FAIL %s: %s\n", file, err)
+			failed++
+			continue
+		}
+
+		fmt.Fprintf(p.stdout, "OK   %s\n", file)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed vault verification", failed, len(files))
+	}
+
+	fmt.Fprintf(p.stdout, "Verified %d vault files\n", len(files))
+	return nil
+}
+
+func verifyVaultFile(file string, keys []vaultKey) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	if !vault.IsEncrypted(content) {
+		return errors.New("file is not encrypted")
+	}
+
+	if _, err := decryptVaultFile(content, keys); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func decryptVaultFile(content []byte, keys []vaultKey) ([]byte, error) {
+	env, err := vault.Parse(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptVault(env, keys)
+}
+
+// vaultEncryptString encrypts the content and renders it as a YAML
+// !vault block, either to the output file or to stdout.
+func (p *Plugin) vaultEncryptString(keys []vaultKey) error {
+	key, err := p.encryptKey(keys)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := vault.Encrypt([]byte(p.Config.Content), key.password, key.envelopeLabel())
+	if err != nil {
+		return errors.Wrap(err, "failed to encrypt content")
+	}
+
+	var block strings.Builder
+	block.WriteString("!vault |\n")
+
+	for _, line := range strings.Split(strings.TrimRight(string(encrypted), "\n"), "\n") {
+		block.WriteString("          ")
+		block.WriteString(line)
+		block.WriteString("\n")
+	}
+
+	if p.Config.Output == "" {
+		fmt.Fprint(p.stdout, block.String())
+		return nil
+	}
+
+	if err := p.writeVaultOutput(p.Config.Output, []byte(block.String()), 0600); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(p.stdout, "Encryption successful")
+	return nil
+}
+
+// writeVaultOutput writes the result to the file, or to stdout for "-".
+func (p *Plugin) writeVaultOutput(path string, content []byte, perm os.FileMode) error {
+	if path == "-" {
+		_, err := p.stdout.Write(content)
+		return err
+	}
+
+	if err := os.WriteFile(path, content, perm); err != nil {
+		return errors.Wrapf(err, "failed to write %s", path)
+	}
+
+	return nil
+}
+
+// splitList splits a comma or newline separated setting into its
+// non-empty entries.
+func splitList(value string) []string {
+	var (
+		list []string
+	)
+
+	for _, entry := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+
+	return list
+}
+
+// vaultSecret strips the line endings ansible removes from password files.
+func vaultSecret(secret string) []byte {
+	return []byte(strings.Trim(secret, "\r\n"))
+}
+
+// vaultLabel extracts the label of a label@source vault identity. The
+// default identity is written without a label, like ansible-vault does.
+func vaultLabel(vaultID string) string {
+	i := strings.Index(vaultID, "@")
+	if i < 0 {
+		return ""
+	}
+
+	if label := vaultID[:i]; label != "default" {
+		return label
+	}
+
+	return ""
+}
@@ -0,0 +1,46 @@
+package vault
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"hash"
+)
+
+// pbkdf2 derives a key from the password as described in RFC 8018.
+func pbkdf2(password, salt []byte, iterations, keyLen int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+
+	var (
+		counter [4]byte
+		derived = make([]byte, 0, blocks*hashLen)
+		u       = make([]byte, hashLen)
+	)
+
+	for block := 1; block <= blocks; block++ {
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(counter[:])
+		u = prf.Sum(u[:0])
+
+		t := make([]byte, hashLen)
+		copy(t, u)
+
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+
+		derived = append(derived, t...)
+	}
+
+	return derived[:keyLen]
+}
@@ -0,0 +1,247 @@
+// Package vault implements the Ansible Vault file format.
+//
+// Both the 1.1 format and the 1.2 format, which adds a vault-id label to
+// the header, are supported with the AES256 cipher: keys are derived with
+// PBKDF2-SHA256, the payload is encrypted with AES-CTR and authenticated
+// with HMAC-SHA256, exactly like ansible-vault does.
+package vault
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Envelope header values.
+const (
+	Header       = "$ANSIBLE_VAULT"
+	Version11    = "1.1"
+	Version12    = "1.2"
+	CipherAES256 = "AES256"
+)
+
+const (
+	saltLen    = 32
+	keyLen     = 32
+	ivLen      = 16
+	iterations = 10000
+	lineWidth  = 80
+)
+
+var (
+	// ErrNotVault is returned when the data has no vault header.
+	ErrNotVault = errors.New("vault: data is not a vault envelope")
+
+	// ErrInvalidFormat is returned when the envelope is malformed.
+	ErrInvalidFormat = errors.New("vault: invalid vault format")
+
+	// ErrUnsupportedCipher is returned for ciphers other than AES256.
+	ErrUnsupportedCipher = errors.New("vault: unsupported cipher")
+
+	// ErrDecrypt is returned when the HMAC does not match, which usually
+	// means the password is wrong.
+	ErrDecrypt = errors.New("vault: decryption failed, wrong password or corrupted data")
+)
+
+// Envelope is a parsed vault file.
+type Envelope struct {
+	Version string
+	Cipher  string
+	Label   string
+
+	salt       []byte
+	hmac       []byte
+	ciphertext []byte
+}
+
+// IsEncrypted reports whether the data starts with a vault header.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte(Header+";"))
+}
+
+// Parse splits a vault envelope into its header fields and payload.
+func Parse(data []byte) (*Envelope, error) {
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	header := strings.Split(strings.TrimSpace(lines[0]), ";")
+	if header[0] != Header {
+		return nil, ErrNotVault
+	}
+
+	if len(header) < 3 {
+		return nil, fmt.Errorf("%w: incomplete header", ErrInvalidFormat)
+	}
+
+	env := &Envelope{
+		Version: header[1],
+		Cipher:  strings.TrimSpace(header[2]),
+	}
+
+	switch env.Version {
+	case Version11:
+	case Version12:
+		if len(header) < 4 {
+			return nil, fmt.Errorf("%w: missing vault-id label", ErrInvalidFormat)
+		}
+		env.Label = strings.TrimSpace(header[3])
+	default:
+		return nil, fmt.Errorf("%w: unknown version %s", ErrInvalidFormat, env.Version)
+	}
+
+	if env.Cipher != CipherAES256 {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCipher, env.Cipher)
+	}
+
+	var body strings.Builder
+	for _, line := range lines[1:] {
+		body.WriteString(strings.TrimSpace(line))
+	}
+
+	payload, err := hex.DecodeString(body.String())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFormat, err)
+	}
+
+	parts := bytes.Split(payload, []byte("\n"))
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: unexpected payload", ErrInvalidFormat)
+	}
+
+	fields := make([][]byte, len(parts))
+	for i, part := range parts {
+		if fields[i], err = hex.DecodeString(string(part)); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFormat, err)
+		}
+	}
+
+	env.salt, env.hmac, env.ciphertext = fields[0], fields[1], fields[2]
+
+	return env, nil
+}
+
+// Encrypt encrypts the plaintext with the password. A non-empty label
+// produces a 1.2 envelope carrying the vault-id, otherwise 1.1 is used.
+func Encrypt(plaintext, password []byte, label string) ([]byte, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("vault: failed to generate salt: %w", err)
+	}
+
+	return encrypt(plaintext, password, label, salt)
+}
+
+func encrypt(plaintext, password []byte, label string, salt []byte) ([]byte, error) {
+	key, hmacKey, iv := deriveKeys(password, salt)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext := pad(plaintext, aes.BlockSize)
+	cipher.NewCTR(block, iv).XORKeyStream(ciphertext, ciphertext)
+
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(ciphertext)
+
+	payload := strings.Join([]string{
+		hex.EncodeToString(salt),
+		hex.EncodeToString(mac.Sum(nil)),
+		hex.EncodeToString(ciphertext),
+	}, "\n")
+
+	body := hex.EncodeToString([]byte(payload))
+
+	var out strings.Builder
+
+	if label != "" {
+		fmt.Fprintf(&out, "%s;%s;%s;%s\n", Header, Version12, CipherAES256, label)
+	} else {
+		fmt.Fprintf(&out, "%s;%s;%s\n", Header, Version11, CipherAES256)
+	}
+
+	for i := 0; i < len(body); i += lineWidth {
+		end := i + lineWidth
+		if end > len(body) {
+			end = len(body)
+		}
+
+		out.WriteString(body[i:end])
+		out.WriteString("\n")
+	}
+
+	return []byte(out.String()), nil
+}
+
+// Decrypt parses the envelope and decrypts it with the password.
+func Decrypt(data, password []byte) ([]byte, error) {
+	env, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return env.Decrypt(password)
+}
+
+// Decrypt decrypts the envelope payload with the password.
+func (e *Envelope) Decrypt(password []byte) ([]byte, error) {
+	key, hmacKey, iv := deriveKeys(password, e.salt)
+
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(e.ciphertext)
+
+	if !hmac.Equal(mac.Sum(nil), e.hmac) {
+		return nil, ErrDecrypt
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := make([]byte, len(e.ciphertext))
+	cipher.NewCTR(block, iv).XORKeyStream(plaintext, e.ciphertext)
+
+	return unpad(plaintext, aes.BlockSize)
+}
+
+func deriveKeys(password, salt []byte) ([]byte, []byte, []byte) {
+	derived := pbkdf2(password, salt, iterations, 2*keyLen+ivLen, sha256.New)
+	return derived[:keyLen], derived[keyLen : 2*keyLen], derived[2*keyLen:]
+}
+
+// pad applies PKCS#7 padding, which ansible-vault uses even in CTR mode.
+func pad(data []byte, size int) []byte {
+	n := size - len(data)%size
+
+	padded := make([]byte, len(data), len(data)+n)
+	copy(padded, data)
+
+	return append(padded, bytes.Repeat([]byte{byte(n)}, n)...)
+}
+
+func unpad(data []byte, size int) ([]byte, error) {
+	if len(data) == 0 || len(data)%size != 0 {
+		return nil, fmt.Errorf("%w: invalid padding", ErrInvalidFormat)
+	}
+
+	n := int(data[len(data)-1])
+	if n == 0 || n > size || n > len(data) {
+		return nil, fmt.Errorf("%w: invalid padding", ErrInvalidFormat)
+	}
+
+	for _, b := range data[len(data)-n:] {
+		if int(b) != n {
+			return nil, fmt.Errorf("%w: invalid padding", ErrInvalidFormat)
+		}
+	}
+
+	return data[:len(data)-n], nil
+}
@@ -0,0 +1,269 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Fixtures produced by ansible-vault, taken from the ansible unit tests.
+const (
+	fixture11 = `$ANSIBLE_VAULT;1.1;AES256
+62303130653266653331306264616235333735323636616539316433666463323964623162386137
+3961616263373033353631316333623566303532663065310a393036623466376263393961326530
+64336561613965383835646464623865663966323464653236343638373165343863623638316664
+3631633031323837340a396530313963373030343933616133393566366137363761373930663833
+3739`
+
+	fixture12 = `$ANSIBLE_VAULT;1.2;AES256;ansible_devel
+65616435333934613466373335363332373764363365633035303466643439313864663837393234
+3330656363343637313962633731333237313636633534630a386264363438363362326132363239
+39363166646664346264383934393935653933316263333838386362633534326664646166663736
+6462303664383765650a356637643633366663643566353036303162386237336233393065393164
+6264`
+
+	fixturePassword = "ansible"
+)
+
+func TestDecryptFixtures(t *testing.T) {
+	tests := []struct {
+		name      string
+		vaulttext string
+		version   string
+		label     string
+		plaintext string
+	}{
+		{"1.1", fixture11, Version11, "", "foo\n"},
+		{"1.2", fixture12, Version12, "ansible_devel", "foo bar\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := Parse([]byte(tt.vaulttext))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if env.Version != tt.version || env.Cipher != CipherAES256 || env.Label != tt.label {
+				t.Errorf("got header %s;%s;%s, want %s;%s;%s", env.Version, env.Cipher, env.Label, tt.version, CipherAES256, tt.label)
+			}
+
+			plaintext, err := env.Decrypt([]byte(fixturePassword))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(plaintext) != tt.plaintext {
+				t.Errorf("got %q, want %q", plaintext, tt.plaintext)
+			}
+		})
+	}
+}
+
+func TestDecryptWrongPassword(t *testing.T) {
+	for _, vaulttext := range []string{fixture11, fixture12} {
+		if _, err := Decrypt([]byte(vaulttext), []byte("wrong")); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("got %v, want %v", err, ErrDecrypt)
+		}
+	}
+}
+
+func TestDecryptTamperedCiphertext(t *testing.T) {
+	env, err := Parse([]byte(fixture11))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env.ciphertext[0] ^= 1
+
+	if _, err := env.Decrypt([]byte(fixturePassword)); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("got %v, want %v", err, ErrDecrypt)
+	}
+}
+
+// payload hex encodes the vault payload made of the given lines.
+func payload(lines ...string) string {
+	return hex.EncodeToString([]byte(strings.Join(lines, "\n")))
+}
+
+func TestParseMalformed(t *testing.T) {
+	valid := strings.SplitN(fixture11, "\n", 2)[1]
+
+	tests := []struct {
+		name      string
+		vaulttext string
+		err       error
+	}{
+		{"plaintext", "foo: bar\n", ErrNotVault},
+		{"empty", "", ErrNotVault},
+		{"wrong header", "$ANSIBLE_VALT;1.1;AES256\n" + valid, ErrNotVault},
+		{"incomplete header", "$ANSIBLE_VAULT;1.1\n" + valid, ErrInvalidFormat},
+		{"unknown version", "$ANSIBLE_VAULT;1.3;AES256\n" + valid, ErrInvalidFormat},
+		{"missing label", "$ANSIBLE_VAULT;1.2;AES256\n" + valid, ErrInvalidFormat},
+		{"unsupported cipher", "$ANSIBLE_VAULT;1.1;AES\n" + valid, ErrUnsupportedCipher},
+		{"body not hex", "$ANSIBLE_VAULT;1.1;AES256\nxyz\n", ErrInvalidFormat},
+		{"body odd length", "$ANSIBLE_VAULT;1.1;AES256\n" + valid[:len(valid)-1], ErrInvalidFormat},
+		{"missing body", "$ANSIBLE_VAULT;1.1;AES256\n", ErrInvalidFormat},
+		{"two fields", "$ANSIBLE_VAULT;1.1;AES256\n" + payload("00", "00"), ErrInvalidFormat},
+		{"four fields", "$ANSIBLE_VAULT;1.1;AES256\n" + payload("00", "00", "00", "00"), ErrInvalidFormat},
+		{"field not hex", "$ANSIBLE_VAULT;1.1;AES256\n" + payload("00", "zz", "00"), ErrInvalidFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.vaulttext)); !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestIsEncrypted(t *testing.T) {
+	tests := []struct {
+		data string
+		want bool
+	}{
+		{fixture11, true},
+		{"\n  " + fixture12, true},
+		{"foo: bar", false},
+		{"$ANSIBLE_VAULT", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsEncrypted([]byte(tt.data)); got != tt.want {
+			t.Errorf("IsEncrypted(%q) = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		plaintext string
+		label     string
+		header    string
+	}{
+		{"1.1", "secret: value\n", "", "$ANSIBLE_VAULT;1.1;AES256"},
+		{"1.2", "secret: value\n", "prod", "$ANSIBLE_VAULT;1.2;AES256;prod"},
+		{"empty", "", "", "$ANSIBLE_VAULT;1.1;AES256"},
+		{"full block", strings.Repeat("x", 32), "", "$ANSIBLE_VAULT;1.1;AES256"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vaulttext, err := Encrypt([]byte(tt.plaintext), []byte("password"), tt.label)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lines := strings.Split(strings.TrimRight(string(vaulttext), "\n"), "\n")
+
+			if lines[0] != tt.header {
+				t.Errorf("got header %q, want %q", lines[0], tt.header)
+			}
+
+			for _, line := range lines[1:] {
+				if len(line) > lineWidth {
+					t.Errorf("line exceeds %d characters: %q", lineWidth, line)
+				}
+			}
+
+			plaintext, err := Decrypt(vaulttext, []byte("password"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(plaintext) != tt.plaintext {
+				t.Errorf("got %q, want %q", plaintext, tt.plaintext)
+			}
+		})
+	}
+}
+
+func TestPBKDF2(t *testing.T) {
+	// test vectors of RFC 7914 section 11
+	tests := []struct {
+		password   string
+		salt       string
+		iterations int
+		want       string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2([]byte(tt.password), []byte(tt.salt), tt.iterations, 64, sha256.New))
+
+		if got != tt.want {
+			t.Errorf("pbkdf2(%q, %q, %d) = %s, want %s", tt.password, tt.salt, tt.iterations, got, tt.want)
+		}
+	}
+}
+
+func TestPad(t *testing.T) {
+	for n := 0; n <= 33; n++ {
+		data := bytes.Repeat([]byte{'a'}, n)
+		padded := pad(data, 16)
+
+		if len(padded)%16 != 0 || len(padded) <= n || len(padded) > n+16 {
+			t.Errorf("pad of %d bytes has length %d", n, len(padded))
+		}
+
+		unpadded, err := unpad(padded, 16)
+		if err != nil {
+			t.Fatalf("unpad of %d bytes: %s", n, err)
+		}
+
+		if !bytes.Equal(unpadded, data) {
+			t.Errorf("unpad of %d bytes returned %q", n, unpadded)
+		}
+	}
+}
+
+func TestUnpad(t *testing.T) {
+	block := func(data string, padding byte, n int) []byte {
+		return append([]byte(data), bytes.Repeat([]byte{padding}, n)...)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+		err  bool
+	}{
+		{"single byte", block("0123456789abcde", 1, 1), "0123456789abcde", false},
+		{"full block", block("", 16, 16), "", false},
+		{"second block", block("0123456789abcdef", 16, 16), "0123456789abcdef", false},
+		{"empty", nil, "", true},
+		{"partial block", []byte("0123456789"), "", true},
+		{"zero padding", block("0123456789abcde", 0, 1), "", true},
+		{"padding exceeds block", block("0123456789abcde", 17, 1), "", true},
+		{"inconsistent padding", append(block("0123456789abc", 3, 2), 2), "", true},
+		{"short padding run", block("0123456789ab", 4, 4)[:15], "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := unpad(tt.data, 16)
+
+			if tt.err {
+				if !errors.Is(err, ErrInvalidFormat) {
+					t.Errorf("got %v, want %v", err, ErrInvalidFormat)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates the files below dir and returns dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) string {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestVaultInputs(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(), map[string]string{
+		"group_vars/prod/vault.yml":      "a: 1\n",
+		"group_vars/stage/vault.yml":     "b: 2\n",
+		"group_vars/stage/.git/HEAD":     "ref\n",
+		"group_vars/stage/nested/db.yml": "c: 3\n",
+	})
+
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	tests := []struct {
+		name   string
+		action string
+		input  string
+		want   []string
+		err    string
+	}{
+		{
+			name:   "literal paths",
+			action: ActionVerify,
+			input:  path("group_vars/prod/vault.yml") + "," + path("group_vars/stage/vault.yml"),
+			want:   []string{path("group_vars/prod/vault.yml"), path("group_vars/stage/vault.yml")},
+		},
+		{
+			name:   "missing literal path",
+			action: ActionVerify,
+			input:  path("group_vars/prod/vault.yml") + "," + path("group_vars/stage/vaul.yml"),
+			err:    "file not found: " + path("group_vars/stage/vaul.yml"),
+		},
+		{
+			name:   "missing literal path on encrypt",
+			action: ActionEncrypt,
+			input:  path("group_vars/stage/vaul.yml"),
+			err:    "file not found",
+		},
+		{
+			name:   "glob",
+			action: ActionVerify,
+			input:  path("group_vars/*/vault.yml"),
+			want:   []string{path("group_vars/prod/vault.yml"), path("group_vars/stage/vault.yml")},
+		},
+		{
+			name:   "empty glob on verify",
+			action: ActionVerify,
+			input:  path("group_vars/prod/vault.yml") + "\n" + path("host_vars/*/vault.yml"),
+			err:    "no files matched " + path("host_vars/*/vault.yml"),
+		},
+		{
+			name:   "empty glob on encrypt",
+			action: ActionEncrypt,
+			input:  path("group_vars/prod/vault.yml") + "\n" + path("host_vars/*/vault.yml"),
+			want:   []string{path("group_vars/prod/vault.yml")},
+		},
+		{
+			name:   "directory",
+			action: ActionVerify,
+			input:  path("group_vars/stage"),
+			want:   []string{path("group_vars/stage/nested/db.yml"), path("group_vars/stage/vault.yml")},
+		},
+		{
+			name:   "glob matching directories",
+			action: ActionVerify,
+			input:  path("group_vars/*"),
+			want: []string{
+				path("group_vars/prod/vault.yml"),
+				path("group_vars/stage/nested/db.yml"),
+				path("group_vars/stage/vault.yml"),
+			},
+		},
+		{
+			name:   "directory with output",
+			action: ActionEncrypt,
+			input:  path("group_vars/stage"),
+			err:    "output can only be used with a single input file",
+		},
+		{
+			name:   "nothing matched",
+			action: ActionEncrypt,
+			input:  path("host_vars/*/vault.yml"),
+			err:    "no files matched",
+		},
+	}
+
+	output := map[string]string{
+		"directory with output": path("out.yml"),
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Plugin{
+				Config: Config{
+					Action: tt.action,
+					Input:  tt.input,
+					Output: output[tt.name],
+				},
+			}
+
+			files, err := p.vaultInputs()
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.Join(files, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got files\n%s\nwant\n%s", strings.Join(files, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestVaultBatchRollback(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(), map[string]string{
+		"first.yml":  "first: 1\n",
+		"second.yml": "second: 2\n",
+		"third.yml":  "third: 3\n",
+	})
+
+	files := []string{
+		filepath.Join(dir, "first.yml"),
+		filepath.Join(dir, "second.yml"),
+		filepath.Join(dir, "third.yml"),
+	}
+
+	original := map[string][]byte{}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		original[file] = content
+	}
+
+	if err := os.Chmod(files[0], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var processed []string
+
+	p := &Plugin{
+		Config: Config{Action: ActionEncrypt},
+		stdout: io.Discard,
+		stderr: io.Discard,
+	}
+
+	err := p.vaultBatch(ActionEncrypt, files, func(file string) error {
+		processed = append(processed, file)
+
+		// the failing file is modified as well, like a partial write
+		if err := os.WriteFile(file, []byte("$ANSIBLE_VAULT;1.1;AES256\n"), 0644); err != nil {
+			return err
+		}
+
+		if file == files[1] {
+			return errors.New("boom")
+		}
+
+		return nil
+	})
+
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("got error %v, want boom", err)
+	}
+
+	if len(processed) != 2 {
+		t.Errorf("processed %v, expected to stop at the failing file", processed)
+	}
+
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(content, original[file]) {
+			t.Errorf("%s not restored: %q", file, content)
+		}
+	}
+
+	info, err := os.Stat(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("got mode %v, want 0600", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// virtualenvMarker is created once the requirements are installed into a
+// cached virtualenv, so an interrupted install is never reused.
+const virtualenvMarker = ".drone-ansible-installed"
+
+// setupVirtualenv creates a virtualenv and installs the requirements into
+// it, or reuses the cached one for the same requirements, constraints and
+// python version. Afterwards all commands run from its bin directory.
+func (p *Plugin) setupVirtualenv() error {
+	dir, cached, unlock, err := p.virtualenvDir()
+	if err != nil {
+		return err
+	}
+
+	defer unlock()
+
+	if cached {
+		fmt.Fprintf(p.stdout, "Using cached virtualenv from %s\n", dir)
+		p.virtualenv = dir
+
+		return nil
+	}
+
+	if err := p.runPlan(p.Config.virtualenvCommand(dir)); err != nil {
+		return errors.Wrap(err, "failed to create virtualenv")
+	}
+
+	// pip resolves to the virtualenv from now on
+	p.virtualenv = dir
+
+	if p.Config.Requirements != "" {
+		if err := p.runPlan(p.Config.requirementsCommand()); err != nil {
+			return err
+		}
+	}
+
+	if p.Config.VirtualenvCacheDir == "" || p.Config.DryRun {
+		return nil
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, virtualenvMarker), nil, 0644); err != nil {
+		return errors.Wrap(err, "failed to store virtualenv cache")
+	}
+
+	fmt.Fprintf(p.stdout, "Stored virtualenv in %s\n", dir)
+
+	return nil
+}
+
+// prepareVirtualenv sets up the virtualenv if enabled.
+func (p *Plugin) prepareVirtualenv() error {
+	if !p.Config.Virtualenv {
+		return nil
+	}
+	return p.setupVirtualenv()
+}
+
+// virtualenvDir returns the directory of the virtualenv and whether it is
+// already populated. Without a cache directory every build gets its own
+// virtualenv which is removed afterwards. A cached virtualenv is locked
+// until the returned function is called, so concurrent builds wait for
+// the install instead of clearing it.
+func (p *Plugin) virtualenvDir() (string, bool, func(), error) {
+	unlock := func() {}
+
+	if p.Config.VirtualenvCacheDir == "" {
+		dir, err := os.MkdirTemp("", "venv-")
+		if err != nil {
+			return "", false, nil, errors.Wrap(err, "failed to create virtualenv directory")
+		}
+
+		p.removeLater(dir)
+
+		return dir, false, unlock, nil
+	}
+
+	key, err := p.virtualenvKey()
+	if err != nil {
+		return "", false, nil, err
+	}
+
+	// virtualenvs are not relocatable, so the path has to be absolute
+	// and the virtualenv is created in place
+	dir, err := filepath.Abs(filepath.Join(p.Config.VirtualenvCacheDir, key))
+	if err != nil {
+		return "", false, nil, errors.Wrap(err, "failed to resolve virtualenv cache directory")
+	}
+
+	if virtualenvInstalled(dir) {
+		return dir, true, unlock, nil
+	}
+
+	if p.Config.DryRun {
+		return dir, false, unlock, nil
+	}
+
+	if err := os.MkdirAll(p.Config.VirtualenvCacheDir, 0755); err != nil {
+		return "", false, nil, errors.Wrap(err, "failed to create virtualenv cache directory")
+	}
+
+	unlock, err = lockFile(dir+".lock", func() {
+		fmt.Fprintf(p.stdout, "Waiting for another build to install the virtualenv in %s\n", dir)
+	})
+	if err != nil {
+		return "", false, nil, errors.Wrap(err, "failed to lock virtualenv cache")
+	}
+
+	// the build holding the lock before may have completed the install
+	if virtualenvInstalled(dir) {
+		return dir, true, unlock, nil
+	}
+
+	// drop whatever an interrupted install left behind
+	if err := os.RemoveAll(dir); err != nil {
+		unlock()
+		return "", false, nil, errors.Wrap(err, "failed to clear virtualenv cache")
+	}
+
+	return dir, false, unlock, nil
+}
+
+func virtualenvInstalled(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, virtualenvMarker))
+	return err == nil
+}
+
+// virtualenvKey hashes the requirements, the constraints and the python
+// version.
+func (p *Plugin) virtualenvKey() (string, error) {
+	hash := sha256.New()
+
+	for _, path := range append([]string{p.Config.Requirements}, p.Config.Constraints...) {
+		if path == "" {
+			continue
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to read %s", path)
+		}
+
+		hash.Write(content)
+		hash.Write([]byte{0})
+	}
+
+	var version bytes.Buffer
+
+	cmd, err := p.command(p.Config.pythonVersionCommand())
+	if err != nil {
+		return "", err
+	}
+
+	cmd.Stdout = &version
+	cmd.Stderr = p.stderr
+
+	if err := p.runCommand(cmd); err != nil {
+		return "", errors.Wrap(err, "failed to determine the python version")
+	}
+
+	hash.Write(bytes.TrimSpace(version.Bytes()))
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// runPlan runs a planned command with the plugin output.
+func (p *Plugin) runPlan(plan Command) error {
+	cmd, err := p.command(plan)
+	if err != nil {
+		return err
+	}
+
+	cmd.Stdout = p.stdout
+	cmd.Stderr = p.stderr
+
+	trace(p.stdout, cmd)
+
+	return p.runCommand(cmd)
+}
+
+// executable resolves a bare executable name to the virtualenv, falling
+// back to the PATH for executables the virtualenv does not provide.
+func (p *Plugin) executable(name string) string {
+	if p.virtualenv == "" || strings.ContainsRune(name, os.PathSeparator) {
+		return name
+	}
+
+	path := filepath.Join(virtualenvBin(p.virtualenv), name)
+
+	// nothing got installed in a dry run, show the intended executable
+	if _, err := os.Stat(path); err == nil || p.Config.DryRun {
+		return path
+	}
+
+	return name
+}
+
+func virtualenvBin(dir string) string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(dir, "Scripts")
+	}
+	return filepath.Join(dir, "bin")
+}